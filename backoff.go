@@ -0,0 +1,137 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How long a provider is left alone after it says it will not answer, when it
+// does not say for how long itself
+const (
+	rateLimitBackoff = time.Minute
+	quotaBackoff     = time.Hour
+	// the credentials are not going to fix themselves, but they may be
+	// renewed upstream
+	authBackoff = time.Hour
+)
+
+// backoffProvider -
+// Stops asking a provider that is rate limited, out of quota, or rejecting
+// its credentials, until it is likely to answer again. Calls made meanwhile
+// fail straight away with the error that started the wait.
+type backoffProvider struct {
+	p Provider
+
+	mu    sync.Mutex
+	until time.Time
+	err   error
+}
+
+func newBackoffProvider(p Provider) *backoffProvider {
+	return &backoffProvider{p: p}
+}
+
+// Name -
+func (b *backoffProvider) Name() string {
+	return providerName(b.p)
+}
+
+// GetWeatherContext -
+func (b *backoffProvider) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	if err := b.waiting(); err != nil {
+		return Observation{}, err
+	}
+	val, err := b.p.GetWeatherContext(ctx, loc)
+	b.observe(err)
+	return val, err
+}
+
+// GetForecastContext -
+// Providers that cannot forecast report ErrNotSupported.
+func (b *backoffProvider) GetForecastContext(ctx context.Context, loc Location, hours int) (Forecast, error) {
+	f, ok := b.p.(Forecaster)
+	if !ok {
+		return Forecast{}, ErrNotSupported
+	}
+	if err := b.waiting(); err != nil {
+		return Forecast{}, err
+	}
+	val, err := f.GetForecastContext(ctx, loc, hours)
+	b.observe(err)
+	return val, err
+}
+
+// GetHistoryContext -
+// Providers without history report ErrNotSupported.
+func (b *backoffProvider) GetHistoryContext(ctx context.Context, loc Location, date time.Time) (History, error) {
+	h, ok := b.p.(HistoryProvider)
+	if !ok {
+		return History{}, ErrNotSupported
+	}
+	if err := b.waiting(); err != nil {
+		return History{}, err
+	}
+	val, err := h.GetHistoryContext(ctx, loc, date)
+	b.observe(err)
+	return val, err
+}
+
+// waiting -
+// The error to fail with while backing off, otherwise nil.
+func (b *backoffProvider) waiting() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil || !timeNow().Before(b.until) {
+		return nil
+	}
+	return &backoffError{until: b.until, err: b.err}
+}
+
+// observe -
+// Start backing off when err says the provider will not answer for a while.
+func (b *backoffProvider) observe(err error) {
+	var wait time.Duration
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, ErrRateLimited):
+		wait = rateLimitBackoff
+	case errors.Is(err, ErrQuotaExceeded):
+		wait = quotaBackoff
+	case errors.Is(err, ErrAuth):
+		wait = authBackoff
+	default:
+		return
+	}
+	if after, ok := RetryAfter(err); ok {
+		wait = after
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.until, b.err = timeNow().Add(wait), err
+}
+
+// backoffError -
+// A call that was not made, it still reports why with errors.Is.
+type backoffError struct {
+	until time.Time
+	err   error
+}
+
+// Error -
+func (e *backoffError) Error() string {
+	return fmt.Sprintf("backing off until %s: %v", e.until.UTC().Format(time.RFC3339), e.err)
+}
+
+// Unwrap -
+func (e *backoffError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfter -
+func (e *backoffError) RetryAfter() (time.Duration, bool) {
+	return e.until.Sub(timeNow()), true
+}
@@ -0,0 +1,107 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedProvider answers each call with the next error, and counts calls
+type scriptedProvider struct {
+	errs  []error
+	calls int
+}
+
+func (s *scriptedProvider) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	err := s.errs[s.calls]
+	s.calls++
+	return Observation{}, err
+}
+
+// retryAfterError says how long to wait
+type retryAfterError struct {
+	error
+	wait time.Duration
+}
+
+func (r *retryAfterError) Unwrap() error {
+	return r.error
+}
+
+func (r *retryAfterError) RetryAfter() (time.Duration, bool) {
+	return r.wait, true
+}
+
+func TestBackoffProvider(t *testing.T) {
+	start := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	testcases := map[string]struct {
+		err error
+		// how long calls are refused for, zero when they are not
+		wait time.Duration
+	}{
+		"other error":       {err: fmt.Errorf("fake error")},
+		"unknown location":  {err: fmt.Errorf("fake %w", ErrUnknownLocation)},
+		"rate limited":      {err: fmt.Errorf("fake %w", ErrRateLimited), wait: rateLimitBackoff},
+		"quota exceeded":    {err: ErrQuotaExceeded, wait: quotaBackoff},
+		"bad credentials":   {err: ErrAuth, wait: authBackoff},
+		"told how long":     {err: &retryAfterError{error: ErrRateLimited, wait: 5 * time.Second}, wait: 5 * time.Second},
+		"quota renewed at":  {err: &retryAfterError{error: ErrQuotaExceeded, wait: 24 * time.Hour}, wait: 24 * time.Hour},
+		"wait is not a cap": {err: &retryAfterError{error: fmt.Errorf("fake error"), wait: time.Hour}},
+	}
+	defer func() { timeNow = time.Now }()
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			timeNow = func() time.Time { return start }
+			p := &scriptedProvider{errs: []error{tc.err, nil, nil}}
+			b := newBackoffProvider(p)
+
+			_, err := b.GetWeatherContext(context.Background(), Location{})
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, 1, p.calls)
+
+			// just before the wait is over
+			timeNow = func() time.Time { return start.Add(tc.wait - time.Millisecond) }
+			_, err = b.GetWeatherContext(context.Background(), Location{})
+			if tc.wait == 0 {
+				assert.Nil(t, err)
+				assert.Equal(t, 2, p.calls)
+				return
+			}
+			assert.Equal(t, 1, p.calls)
+			assert.True(t, errors.Is(err, tc.err))
+			wait, ok := RetryAfter(err)
+			assert.True(t, ok)
+			assert.Equal(t, time.Millisecond, wait)
+
+			// providers that cannot forecast still say so
+			_, err = b.GetForecastContext(context.Background(), Location{}, 24)
+			assert.True(t, errors.Is(err, ErrNotSupported))
+
+			timeNow = func() time.Time { return start.Add(tc.wait) }
+			_, err = b.GetWeatherContext(context.Background(), Location{})
+			assert.Nil(t, err)
+			assert.Equal(t, 2, p.calls)
+		})
+	}
+}
+
+func TestBackoffProviderSharesWait(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	start := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return start }
+
+	f := &fakeForecaster{err: fmt.Errorf("fake %w", ErrAuth)}
+	b := newBackoffProvider(f)
+	_, err := b.GetForecastContext(context.Background(), Location{}, 24)
+	assert.True(t, errors.Is(err, ErrAuth))
+
+	// the same credentials are used for observations
+	_, err = b.GetWeatherContext(context.Background(), Location{})
+	assert.True(t, errors.Is(err, ErrAuth))
+	assert.EqualError(t, err, "backing off until 2021-11-11T08:00:00Z: fake invalid credentials")
+	assert.Equal(t, 1, f.calls)
+}
@@ -0,0 +1,172 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/shanehowearth/weather/units"
+)
+
+// Limits on batch requests
+const (
+	defaultBatchWorkers = 8
+	maxBatchSize        = 100
+	// a full batch of locations is well under this
+	maxBatchBody = 64 * 1024
+)
+
+// BatchItem -
+// One location in a batch request, in any of the forms accepted by /v1/weather.
+// A plain JSON string is taken as a city name.
+type BatchItem struct {
+	City     string   `json:"city,omitempty"`
+	Country  string   `json:"country,omitempty"`
+	Lat      *float64 `json:"lat,omitempty"`
+	Lon      *float64 `json:"lon,omitempty"`
+	Postcode string   `json:"postcode,omitempty"`
+}
+
+// UnmarshalJSON -
+// Accept "melbourne" as well as {"city":"melbourne"}.
+func (b *BatchItem) UnmarshalJSON(data []byte) error {
+	var city string
+	if err := json.Unmarshal(data, &city); err == nil {
+		*b = BatchItem{City: city}
+		return nil
+	}
+	// a distinct type so this method is not called again
+	type item BatchItem
+	return json.Unmarshal(data, (*item)(b))
+}
+
+// query -
+// The item as the query string of a single lookup.
+func (b BatchItem) query() url.Values {
+	q := url.Values{}
+	if b.City != "" {
+		q.Set("city", b.City)
+	}
+	if b.Country != "" {
+		q.Set("country", b.Country)
+	}
+	if b.Lat != nil {
+		q.Set("lat", strconv.FormatFloat(*b.Lat, 'f', -1, 64))
+	}
+	if b.Lon != nil {
+		q.Set("lon", strconv.FormatFloat(*b.Lon, 'f', -1, 64))
+	}
+	if b.Postcode != "" {
+		q.Set("postcode", b.Postcode)
+	}
+	return q
+}
+
+// BatchResult -
+// The outcome of one location in a batch, either an observation or an error.
+// Status is the status the same lookup on its own would have returned.
+type BatchResult struct {
+	Query       BatchItem         `json:"query"`
+	Status      int               `json:"status"`
+	Observation *Observation      `json:"observation,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Providers   []providerFailure `json:"providers,omitempty"`
+}
+
+// Batch -
+// Look up several locations at once, eg.
+//
+//	POST /v1/weather/batch
+//	["melbourne", {"city":"melbourne","country":"AU"}, {"lat":-37.81,"lon":144.96}]
+//
+// The response holds a result for each location, in the order they were asked
+// for, and is sent with status 200 even when some of them failed.
+func (d *data) Batch(w http.ResponseWriter, r *http.Request) {
+	// only POST allowed
+	if r.Method != http.MethodPost {
+		http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	items := []BatchItem{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&items); err != nil {
+		http.Error(w, "Bad Request, body must be a JSON list of locations", http.StatusBadRequest)
+		return
+	}
+	d.writeBatch(w, r, items)
+}
+
+// writeBatch -
+// Look up each item, through the same cache and providers as a single
+// lookup, with at most batchWorkers running at once. Every observation is in
+// the units asked for in the query string.
+func (d *data) writeBatch(w http.ResponseWriter, r *http.Request, items []BatchItem) {
+	if len(items) < 1 || len(items) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("Bad Request, a batch must have between 1 and %d locations", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	sys, err := requestedUnits(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]BatchResult, len(items))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	workers := d.batchWorkers
+	if workers > len(items) {
+		workers = len(items)
+	}
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = d.batchLookup(r, items[i], sys)
+			}
+		}()
+	}
+	for i := range items {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, struct {
+		Results []BatchResult `json:"results"`
+	}{
+		Results: results,
+	})
+}
+
+func (d *data) batchLookup(r *http.Request, item BatchItem, sys units.System) BatchResult {
+	result := BatchResult{Query: item}
+	loc, err := d.resolve(item.query())
+	if err != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = err.Error()
+		return result
+	}
+	val, failed := d.lookup(r.Context(), loc)
+	if failed != nil {
+		resp := newErrorResponse(failed)
+		result.Status = failed.Status()
+		result.Error = resp.Error
+		result.Providers = resp.Providers
+		return result
+	}
+	if val, err = val.In(sys); err != nil {
+		log.Printf("unable to convert %#v to %v, with error %v", val, sys, err)
+		result.Status = http.StatusInternalServerError
+		result.Error = "Internal Server Error"
+		return result
+	}
+	result.Status = http.StatusOK
+	result.Observation = &val
+	return result
+}
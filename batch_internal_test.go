@@ -0,0 +1,158 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchHandler(t *testing.T) {
+	obs := `{"temperature_degrees":100,"wind_speed":150,"units":{"temperature":"","wind_speed":""},"observed_at":"0001-01-01T00:00:00Z","provider":"","location":""}`
+	testcases := map[string]struct {
+		method  string
+		path    string
+		body    string
+		fakeErr error
+		status  int
+		resp    string
+	}{
+		"get several cities": {
+			method: http.MethodGet,
+			path:   "/v1/weather?city=melbourne&city=Sydney&city=fake",
+			status: http.StatusOK,
+			resp: `{"results":[
+				{"query":{"city":"melbourne"},"status":200,"observation":` + obs + `},
+				{"query":{"city":"Sydney"},"status":200,"observation":` + obs + `},
+				{"query":{"city":"fake"},"status":400,"error":"Sorry, don't know that city \"fake\""}]}`,
+		},
+		"get shares the country": {
+			method: http.MethodGet,
+			path:   "/v1/weather?city=melbourne&city=sydney&country=us",
+			status: http.StatusOK,
+			resp: `{"results":[
+				{"query":{"city":"melbourne","country":"us"},"status":400,"error":"Sorry, don't know that city \"melbourne\" in \"us\""},
+				{"query":{"city":"sydney","country":"us"},"status":400,"error":"Sorry, don't know that city \"sydney\" in \"us\""}]}`,
+		},
+		"post every form": {
+			method: http.MethodPost,
+			path:   "/v1/weather/batch",
+			body:   `["melbourne",{"city":"Melbourne","country":"AU"},{"lat":-37.81,"lon":144.96},{"postcode":"3000","country":"AU"},{"postcode":"3000"}]`,
+			status: http.StatusOK,
+			resp: `{"results":[
+				{"query":{"city":"melbourne"},"status":200,"observation":` + obs + `},
+				{"query":{"city":"Melbourne","country":"AU"},"status":200,"observation":` + obs + `},
+				{"query":{"lat":-37.81,"lon":144.96},"status":200,"observation":` + obs + `},
+				{"query":{"postcode":"3000","country":"AU"},"status":200,"observation":` + obs + `},
+				{"query":{"postcode":"3000"},"status":400,"error":"Bad Request, country is required with a postcode"}]}`,
+		},
+		"post provider failure": {
+			method:  http.MethodPost,
+			path:    "/v1/weather/batch",
+			body:    `["sydney"]`,
+			fakeErr: fmt.Errorf("fake error"),
+			status:  http.StatusOK,
+			resp: `{"results":[
+				{"query":{"city":"sydney"},"status":502,"error":"no provider was able to supply the weather","providers":[{"provider":"fake","error":"fake error"}]}]}`,
+		},
+		"post bad body": {
+			method: http.MethodPost,
+			path:   "/v1/weather/batch",
+			body:   `{"city":"melbourne"}`,
+			status: http.StatusBadRequest,
+			resp:   "Bad Request, body must be a JSON list of locations\n",
+		},
+		"post empty": {
+			method: http.MethodPost,
+			path:   "/v1/weather/batch",
+			body:   `[]`,
+			status: http.StatusBadRequest,
+			resp:   "Bad Request, a batch must have between 1 and 100 locations\n",
+		},
+		"post too many": {
+			method: http.MethodPost,
+			path:   "/v1/weather/batch",
+			body:   "[" + strings.Repeat(`"melbourne",`, 100) + `"melbourne"]`,
+			status: http.StatusBadRequest,
+			resp:   "Bad Request, a batch must have between 1 and 100 locations\n",
+		},
+		"get batch": {
+			method: http.MethodGet,
+			path:   "/v1/weather/batch",
+			status: http.StatusMethodNotAllowed,
+			resp:   "Bad method\n",
+		},
+	}
+	defer func() { timeNow = time.Now }()
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			o, err := New([]Provider{&fakeProvider{}})
+			assert.Nil(t, err)
+			timeNow = time.Now
+			fakeResponse = Observation{Temperature: 100, WindSpeed: 150}
+			fakeResponseErr = tc.fakeErr
+
+			req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			assert.Nil(t, err)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(o.Weather)
+			if strings.HasPrefix(tc.path, "/v1/weather/batch") {
+				handler = o.Batch
+			}
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.status == http.StatusOK {
+				assert.JSONEq(t, tc.resp, rr.Body.String())
+				return
+			}
+			assert.Equal(t, tc.resp, rr.Body.String())
+		})
+	}
+}
+
+// countingProvider records how many lookups run at once
+type countingProvider struct {
+	running, most int32
+}
+
+func (c *countingProvider) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	n := atomic.AddInt32(&c.running, 1)
+	defer atomic.AddInt32(&c.running, -1)
+	for {
+		most := atomic.LoadInt32(&c.most)
+		if n <= most || atomic.CompareAndSwapInt32(&c.most, most, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return Observation{Location: loc.Name}, nil
+}
+
+func TestBatchWorkers(t *testing.T) {
+	p := &countingProvider{}
+	o, err := New([]Provider{p}, WithBatchWorkers(2))
+	assert.Nil(t, err)
+
+	// distinct coordinates so no lookups are shared
+	items := []string{}
+	for i := 0; i < 10; i++ {
+		items = append(items, fmt.Sprintf(`{"lat":%d,"lon":0}`, i))
+	}
+	req, err := http.NewRequest(http.MethodPost, "/v1/weather/batch", strings.NewReader("["+strings.Join(items, ",")+"]"))
+	assert.Nil(t, err)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(o.Batch).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&p.most))
+
+	_, err = New([]Provider{p}, WithBatchWorkers(0))
+	assert.EqualError(t, err, "batch workers must be at least 1")
+}
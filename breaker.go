@@ -0,0 +1,353 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen -
+// The call was not made because the provider's circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState -
+// Whether a circuit breaker lets calls through.
+type BreakerState int
+
+// Circuit breaker states
+const (
+	// BreakerClosed - calls are made as normal
+	BreakerClosed BreakerState = iota
+	// BreakerOpen - calls fail straight away with ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen - the cool-down is over, and a single trial call is
+	// let through to see if the provider has recovered
+	BreakerHalfOpen
+)
+
+var breakerStates = map[BreakerState]string{
+	BreakerClosed:   "closed",
+	BreakerOpen:     "open",
+	BreakerHalfOpen: "half-open",
+}
+
+// String -
+func (s BreakerState) String() string {
+	if name, ok := breakerStates[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// MarshalText -
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerPolicy -
+// When a circuit breaker opens, and for how long.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of failures in a row that opens the
+	// breaker
+	FailureThreshold int
+	// CoolDown is how long the breaker stays open before a trial call is let
+	// through
+	CoolDown time.Duration
+}
+
+// DefaultBreakerPolicy -
+// Open after five failures in a row, and try again after thirty seconds.
+var DefaultBreakerPolicy = BreakerPolicy{
+	FailureThreshold: 5,
+	CoolDown:         30 * time.Second,
+}
+
+// Breaker -
+// A circuit breaker around a provider, see WithBreaker.
+type Breaker struct {
+	p      Provider
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int // in a row
+	// trial is set while the half-open trial call is running
+	trial    bool
+	openedAt time.Time
+	retryAt  time.Time
+	// totals since the breaker was created
+	trips    int
+	rejected int
+}
+
+// WithBreaker -
+// Stop calling p once it has failed policy.FailureThreshold times in a row,
+// failing with ErrCircuitOpen instead, until policy.CoolDown has passed and a
+// trial call succeeds. Only failures that say something about the provider's
+// health count, a permanent error (see IsPermanent) or being rate limited is an
+// answer, and calls abandoned by the caller are ignored.
+func WithBreaker(p Provider, policy BreakerPolicy) (*Breaker, error) {
+	if policy.FailureThreshold < 1 {
+		return nil, fmt.Errorf("breaker failure threshold must be at least 1")
+	}
+	if policy.CoolDown <= 0 {
+		return nil, fmt.Errorf("breaker cool-down must be positive")
+	}
+	return &Breaker{p: p, policy: policy}, nil
+}
+
+// Name -
+func (b *Breaker) Name() string {
+	return providerName(b.p)
+}
+
+// GetWeatherContext -
+func (b *Breaker) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	if err := b.allow(); err != nil {
+		return Observation{}, err
+	}
+	val, err := b.p.GetWeatherContext(ctx, loc)
+	b.record(ctx, err)
+	return val, err
+}
+
+// GetForecastContext -
+// Providers that cannot forecast report ErrNotSupported.
+func (b *Breaker) GetForecastContext(ctx context.Context, loc Location, hours int) (Forecast, error) {
+	f, ok := b.p.(Forecaster)
+	if !ok {
+		return Forecast{}, ErrNotSupported
+	}
+	if err := b.allow(); err != nil {
+		return Forecast{}, err
+	}
+	val, err := f.GetForecastContext(ctx, loc, hours)
+	b.record(ctx, err)
+	return val, err
+}
+
+// GetHistoryContext -
+// Providers without history report ErrNotSupported.
+func (b *Breaker) GetHistoryContext(ctx context.Context, loc Location, date time.Time) (History, error) {
+	h, ok := b.p.(HistoryProvider)
+	if !ok {
+		return History{}, ErrNotSupported
+	}
+	if err := b.allow(); err != nil {
+		return History{}, err
+	}
+	val, err := h.GetHistoryContext(ctx, loc, date)
+	b.record(ctx, err)
+	return val, err
+}
+
+// State -
+// Whether calls are let through now.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.coolDown()
+	return b.state
+}
+
+// BreakerStatus -
+// A snapshot of a circuit breaker, for the admin endpoint and metrics.
+type BreakerStatus struct {
+	Provider            string       `json:"provider"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	// OpenedAt and RetryAt are set while the breaker is open or half-open
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+	// Trips is the number of times the breaker has opened
+	Trips int `json:"trips"`
+	// Rejected is the number of calls failed with ErrCircuitOpen
+	Rejected int `json:"rejected"`
+}
+
+// Status -
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.coolDown()
+	s := BreakerStatus{
+		Provider:            providerName(b.p),
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		Rejected:            b.rejected,
+	}
+	if b.state != BreakerClosed {
+		openedAt, retryAt := b.openedAt, b.retryAt
+		s.OpenedAt, s.RetryAt = &openedAt, &retryAt
+	}
+	return s
+}
+
+// Reset -
+// Close the breaker, eg. once the provider is known to be fixed.
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.failures, b.trial = BreakerClosed, 0, false
+}
+
+// Trip -
+// Open the breaker for a cool-down, eg. to take the provider out of service.
+func (b *Breaker) Trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open()
+}
+
+// allow -
+// Whether a call may be made, otherwise the error to fail it with.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.coolDown()
+	switch {
+	case b.state == BreakerOpen, b.state == BreakerHalfOpen && b.trial:
+		b.rejected++
+		return &openError{provider: providerName(b.p), until: b.retryAt}
+	case b.state == BreakerHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// record -
+// Update the breaker with the outcome of a call made with ctx.
+func (b *Breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	trial := b.trial && b.state == BreakerHalfOpen
+	switch {
+	case err == nil || IsPermanent(err) || errors.Is(err, ErrRateLimited):
+		b.failures = 0
+		if trial {
+			b.state, b.trial = BreakerClosed, false
+		}
+	case ctx.Err() != nil:
+		// the caller went away, which says nothing about the provider, a
+		// trial that was abandoned leaves room for another one
+		if trial {
+			b.trial = false
+		}
+	default:
+		b.failures++
+		if trial || b.state == BreakerClosed && b.failures >= b.policy.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+// open -
+// The caller holds mu.
+func (b *Breaker) open() {
+	now := timeNow()
+	b.state, b.trial = BreakerOpen, false
+	b.openedAt, b.retryAt = now, now.Add(b.policy.CoolDown)
+	b.trips++
+}
+
+// coolDown -
+// Move an open breaker to half-open once the cool-down has passed. The caller
+// holds mu.
+func (b *Breaker) coolDown() {
+	if b.state == BreakerOpen && !timeNow().Before(b.retryAt) {
+		b.state = BreakerHalfOpen
+	}
+}
+
+// openError -
+// A call rejected by an open breaker.
+type openError struct {
+	provider string
+	until    time.Time
+}
+
+// Error -
+func (e *openError) Error() string {
+	return fmt.Sprintf("%v for %s until %s", ErrCircuitOpen, e.provider, e.until.UTC().Format(time.RFC3339))
+}
+
+// Is -
+func (e *openError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// RetryAfter -
+// The rest of the cool-down, a half-open breaker waiting on its trial call
+// may close at any moment.
+func (e *openError) RetryAfter() (time.Duration, bool) {
+	wait := e.until.Sub(timeNow())
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// BreakerAdmin -
+// An endpoint for the breakers. GET lists their status, eg.
+//
+//	{"breakers":[{"provider":"weatherstack","state":"open",...}]}
+//
+// and POST with provider=name&state=open|closed trips or resets one.
+func BreakerAdmin(breakers ...*Breaker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			name := r.FormValue("provider")
+			var b *Breaker
+			for i := range breakers {
+				if breakers[i].Name() == name {
+					b = breakers[i]
+				}
+			}
+			if b == nil {
+				http.Error(w, fmt.Sprintf("Sorry, don't know that provider %q", name), http.StatusNotFound)
+				return
+			}
+			switch r.FormValue("state") {
+			case "open":
+				b.Trip()
+			case "closed":
+				b.Reset()
+			default:
+				http.Error(w, "Bad Request, state must be open or closed", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]BreakerStatus{"breakers": breakerStatuses(breakers)})
+	})
+}
+
+// BreakerMetrics -
+// The status of each breaker, keyed by provider, for publishing with
+// expvar.Publish.
+func BreakerMetrics(breakers ...*Breaker) expvar.Var {
+	return expvar.Func(func() interface{} {
+		metrics := map[string]BreakerStatus{}
+		for _, s := range breakerStatuses(breakers) {
+			metrics[s.Provider] = s
+		}
+		return metrics
+	})
+}
+
+func breakerStatuses(breakers []*Breaker) []BreakerStatus {
+	statuses := make([]BreakerStatus, len(breakers))
+	for i := range breakers {
+		statuses[i] = breakers[i].Status()
+	}
+	return statuses
+}
@@ -0,0 +1,252 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	start := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	down := fmt.Errorf("fake %w", ErrUnavailable)
+	p := &scriptedProvider{errs: []error{
+		// a success resets the count
+		down, nil,
+		// opens
+		down, down,
+		// the trial fails and it opens again
+		down,
+		// the next trial closes it
+		nil,
+		// answers, not failures
+		ErrUnknownLocation, ErrRateLimited, ErrUnknownLocation,
+	}}
+	b, err := WithBreaker(p, BreakerPolicy{FailureThreshold: 2, CoolDown: time.Minute})
+	assert.Nil(t, err)
+	call := func() error {
+		_, err := b.GetWeatherContext(context.Background(), Location{})
+		return err
+	}
+
+	assert.Equal(t, down, call())
+	assert.Nil(t, call())
+	assert.Equal(t, BreakerClosed, b.State())
+
+	assert.Equal(t, down, call())
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Equal(t, down, call())
+	assert.Equal(t, BreakerOpen, b.State())
+
+	// rejected without calling the provider
+	now = start.Add(20 * time.Second)
+	err = call()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.EqualError(t, err, "circuit breaker is open for *weather.scriptedProvider until 2021-11-11T07:01:00Z")
+	wait, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Second, wait)
+	assert.Equal(t, 4, p.calls)
+
+	// a failed trial opens it for another cool-down
+	now = start.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.Equal(t, down, call())
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.True(t, errors.Is(call(), ErrCircuitOpen))
+
+	// a successful trial closes it
+	now = start.Add(2 * time.Minute)
+	assert.Nil(t, call())
+	assert.Equal(t, BreakerClosed, b.State())
+
+	assert.Equal(t, ErrUnknownLocation, call())
+	assert.Equal(t, ErrRateLimited, call())
+	assert.Equal(t, ErrUnknownLocation, call())
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Equal(t, 9, p.calls)
+
+	s := b.Status()
+	assert.Equal(t, 2, s.Trips)
+	assert.Equal(t, 2, s.Rejected)
+	assert.Nil(t, s.OpenedAt)
+}
+
+// blockingProvider fails once released, unless the context is done first
+type blockingProvider struct {
+	release chan struct{}
+	started chan struct{}
+}
+
+func (b *blockingProvider) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	b.started <- struct{}{}
+	select {
+	case <-ctx.Done():
+		return Observation{}, ctx.Err()
+	case <-b.release:
+		return Observation{}, ErrUnavailable
+	}
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	p := &blockingProvider{release: make(chan struct{}), started: make(chan struct{}, 1)}
+	b, err := WithBreaker(p, BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute})
+	assert.Nil(t, err)
+	b.state = BreakerHalfOpen
+
+	// the trial is running, so other calls are turned away
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := b.GetWeatherContext(ctx, Location{})
+		done <- err
+	}()
+	<-p.started
+	_, err = b.GetWeatherContext(context.Background(), Location{})
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	wait, _ := RetryAfter(err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// the caller giving up is not the provider's fault, and makes way for
+	// another trial
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+
+	go func() {
+		_, err := b.GetWeatherContext(context.Background(), Location{})
+		done <- err
+	}()
+	<-p.started
+	close(p.release)
+	assert.Equal(t, ErrUnavailable, <-done)
+	assert.Equal(t, BreakerOpen, b.State())
+}
+
+func TestBreakerForwarding(t *testing.T) {
+	b, err := WithBreaker(&scriptedProvider{}, DefaultBreakerPolicy)
+	assert.Nil(t, err)
+	_, err = b.GetForecastContext(context.Background(), Location{}, 24)
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = b.GetHistoryContext(context.Background(), Location{}, time.Now())
+	assert.Equal(t, ErrNotSupported, err)
+
+	f := &fakeForecaster{err: ErrUnavailable}
+	b, err = WithBreaker(f, BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute})
+	assert.Nil(t, err)
+	_, err = b.GetForecastContext(context.Background(), Location{}, 24)
+	assert.Equal(t, ErrUnavailable, err)
+	// forecasts and observations share the breaker
+	_, err = b.GetWeatherContext(context.Background(), Location{})
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, "fake", b.Name())
+}
+
+func TestWithBreakerValidation(t *testing.T) {
+	_, err := WithBreaker(&scriptedProvider{}, BreakerPolicy{CoolDown: time.Second})
+	assert.EqualError(t, err, "breaker failure threshold must be at least 1")
+	_, err = WithBreaker(&scriptedProvider{}, BreakerPolicy{FailureThreshold: 1})
+	assert.EqualError(t, err, "breaker cool-down must be positive")
+}
+
+func TestBreakerAdmin(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	testcases := map[string]struct {
+		method  string
+		form    url.Values
+		status  int
+		errBody string
+		states  []BreakerState
+	}{
+		"list": {
+			method: http.MethodGet,
+			status: http.StatusOK,
+			states: []BreakerState{BreakerClosed, BreakerClosed},
+		},
+		"trip": {
+			method: http.MethodPost,
+			form:   url.Values{"provider": {"fake"}, "state": {"open"}},
+			status: http.StatusOK,
+			states: []BreakerState{BreakerOpen, BreakerClosed},
+		},
+		"unknown provider": {
+			method:  http.MethodPost,
+			form:    url.Values{"provider": {"other"}, "state": {"open"}},
+			status:  http.StatusNotFound,
+			errBody: "Sorry, don't know that provider \"other\"\n",
+		},
+		"bad state": {
+			method:  http.MethodPost,
+			form:    url.Values{"provider": {"fake"}, "state": {"half-open"}},
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, state must be open or closed\n",
+		},
+		"wrong method": {
+			method:  http.MethodDelete,
+			status:  http.StatusMethodNotAllowed,
+			errBody: "Bad method\n",
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			fake, err := WithBreaker(&fakeProvider{}, DefaultBreakerPolicy)
+			assert.Nil(t, err)
+			other, err := WithBreaker(&scriptedProvider{}, DefaultBreakerPolicy)
+			assert.Nil(t, err)
+
+			req, err := http.NewRequest(tc.method, "/admin/breakers", strings.NewReader(tc.form.Encode()))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			BreakerAdmin(fake, other).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.errBody != "" {
+				assert.Equal(t, tc.errBody, rr.Body.String())
+				return
+			}
+			var body struct {
+				Breakers []struct {
+					Provider string `json:"provider"`
+					State    string `json:"state"`
+				} `json:"breakers"`
+			}
+			assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			assert.Len(t, body.Breakers, len(tc.states))
+			for i := range tc.states {
+				assert.Equal(t, tc.states[i].String(), body.Breakers[i].State)
+			}
+			assert.Equal(t, "fake", body.Breakers[0].Provider)
+		})
+	}
+}
+
+func TestBreakerMetrics(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	b, err := WithBreaker(&fakeProvider{}, DefaultBreakerPolicy)
+	assert.Nil(t, err)
+	b.Trip()
+	_, _ = b.GetWeatherContext(context.Background(), Location{})
+
+	assert.JSONEq(t, `{"fake":{"provider":"fake","state":"open","consecutive_failures":0,
+		"opened_at":"2021-11-11T07:00:00Z","retry_at":"2021-11-11T07:00:30Z","trips":1,"rejected":1}}`,
+		BreakerMetrics(b).String())
+}
@@ -0,0 +1,137 @@
+package weather
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Entry -
+// A cached value and when it was stored.
+type Entry struct {
+	Value  interface{}
+	Stored time.Time
+}
+
+// Cache -
+// Storage for values fetched from the providers.
+// Whether an entry is still fresh is decided by the CachePolicy, so a Cache
+// only needs to store and evict entries. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	Get(key string) (Entry, bool)
+	Set(key string, e Entry)
+}
+
+// CachePolicy -
+// How long cached values may be used for. Each window starts when the value
+// was stored.
+type CachePolicy struct {
+	// TTL is how long a value is served without asking the providers
+	TTL time.Duration
+	// StaleWhileRevalidate is how long after the TTL a value is still served
+	// while a fresh one is fetched in the background
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is how long after the TTL a value is served, marked as
+	// stale, when every provider fails
+	StaleIfError time.Duration
+}
+
+// DefaultCachePolicy -
+// Keeps the historical three second gap between upstream lookups for a city.
+var DefaultCachePolicy = CachePolicy{
+	TTL:          3 * time.Second,
+	StaleIfError: 24 * time.Hour,
+}
+
+// Default number of entries kept by the cache New creates
+const defaultCacheSize = 1000
+
+// freshness of an entry under a policy
+type freshness int
+
+const (
+	fresh freshness = iota
+	revalidate
+	expired
+)
+
+func (p CachePolicy) freshness(e Entry, now time.Time) freshness {
+	age := now.Sub(e.Stored)
+	switch {
+	case age < p.TTL:
+		return fresh
+	case age < p.TTL+p.StaleWhileRevalidate:
+		return revalidate
+	}
+	return expired
+}
+
+func (p CachePolicy) usableOnError(e Entry, now time.Time) bool {
+	return now.Sub(e.Stored) < p.TTL+p.StaleIfError
+}
+
+// LRUCache -
+// In memory Cache holding at most size entries, evicting the least recently
+// used entry when full.
+type LRUCache struct {
+	m     sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry Entry
+}
+
+// NewLRUCache -
+func NewLRUCache(size int) (*LRUCache, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("cache size must be at least 1")
+	}
+	return &LRUCache{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}, nil
+}
+
+// Get -
+func (l *LRUCache) Get(key string) (Entry, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+// Set -
+func (l *LRUCache) Set(key string, e Entry) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if el, ok := l.items[key]; ok {
+		el.Value.(*lruItem).entry = e
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruItem{key: key, entry: e})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruItem).key)
+	}
+}
+
+// Len -
+// Number of entries currently held.
+func (l *LRUCache) Len() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.order.Len()
+}
@@ -0,0 +1,57 @@
+package weather_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLRUCache(t *testing.T) {
+	testcases := map[string]struct {
+		size int
+		err  string
+	}{
+		"successful creation": {size: 1},
+		"zero size":           {err: "cache size must be at least 1"},
+		"negative size":       {size: -1, err: "cache size must be at least 1"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			c, err := weather.NewLRUCache(tc.size)
+			if tc.err == "" {
+				assert.Nil(t, err)
+				assert.NotNil(t, c)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	c, err := weather.NewLRUCache(2)
+	assert.Nil(t, err)
+	stored := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+
+	c.Set("melbourne", weather.Entry{Value: 1, Stored: stored})
+	c.Set("sydney", weather.Entry{Value: 2, Stored: stored})
+	// using melbourne makes sydney the least recently used
+	_, ok := c.Get("melbourne")
+	assert.True(t, ok)
+	c.Set("brisbane", weather.Entry{Value: 3, Stored: stored})
+
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get("sydney")
+	assert.False(t, ok)
+	e, ok := c.Get("melbourne")
+	assert.True(t, ok)
+	assert.Equal(t, weather.Entry{Value: 1, Stored: stored}, e)
+
+	// replacing an entry does not grow the cache
+	c.Set("brisbane", weather.Entry{Value: 4, Stored: stored})
+	assert.Equal(t, 2, c.Len())
+	e, _ = c.Get("brisbane")
+	assert.Equal(t, 4, e.Value)
+}
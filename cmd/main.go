@@ -2,18 +2,173 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"time"
+	// the container has no zoneinfo, forecasts are rolled up by local day
+	_ "time/tzdata"
 
 	"github.com/shanehowearth/weather"
 	"github.com/shanehowearth/weather/providers/openweathermap"
 	"github.com/shanehowearth/weather/providers/weatherstack"
 )
 
+// Maximum time any single upstream provider call may take
+const providerTimeout = 5 * time.Second
+
+// Maximum time all the calls to a provider for one request may take,
+// retries included
+const retryTimeout = 12 * time.Second
+
+// Identifies the service to the providers
+const userAgent = "shanehowearth-weather/1.0"
+
+// How long the hedged strategy waits before trying the next provider
+const hedgeDelay = 500 * time.Millisecond
+
+// Providers disagreeing with the median by more than this are ignored by the
+// consensus strategy, in degrees celsius and metres per second
+const (
+	consensusTemperatureTolerance = 5
+	consensusWindSpeedTolerance   = 3
+)
+
+// Number of observations kept in memory unless CACHE_SIZE is set
+const defaultCacheSize = 1000
+
+// Where the operations endpoints listen unless ADMIN_ADDR is set, only
+// reachable from the same host
+const defaultAdminAddr = "127.0.0.1:8081"
+
+// cachePolicyFromEnv -
+// Start from policy, overriding any of the prefix_TTL, prefix_STALE_... windows
+// that have been set.
+func cachePolicyFromEnv(prefix string, policy weather.CachePolicy) (weather.CachePolicy, error) {
+	windows := map[string]*time.Duration{
+		prefix + "_TTL":                    &policy.TTL,
+		prefix + "_STALE_WHILE_REVALIDATE": &policy.StaleWhileRevalidate,
+		prefix + "_STALE_IF_ERROR":         &policy.StaleIfError,
+	}
+	for name, window := range windows {
+		rWindow := os.Getenv(name)
+		if rWindow == "" {
+			continue
+		}
+		d, err := time.ParseDuration(rWindow)
+		if err != nil {
+			return policy, fmt.Errorf("%s must be a duration such as 30s, got %q", name, rWindow)
+		}
+		*window = d
+	}
+	return policy, nil
+}
+
+// retryPolicyFromEnv -
+// The default retry policy, with RETRY_ATTEMPTS, RETRY_BASE_DELAY, and
+// RETRY_MAX_DELAY overriding it when set.
+func retryPolicyFromEnv() (weather.RetryPolicy, error) {
+	policy := weather.DefaultRetryPolicy
+	if rAttempts := os.Getenv("RETRY_ATTEMPTS"); rAttempts != "" {
+		attempts, err := strconv.Atoi(rAttempts)
+		if err != nil {
+			return policy, fmt.Errorf("RETRY_ATTEMPTS must be an integer, got %q", rAttempts)
+		}
+		policy.Attempts = attempts
+	}
+	delays := map[string]*time.Duration{
+		"RETRY_BASE_DELAY": &policy.BaseDelay,
+		"RETRY_MAX_DELAY":  &policy.MaxDelay,
+	}
+	for name, delay := range delays {
+		rDelay := os.Getenv(name)
+		if rDelay == "" {
+			continue
+		}
+		d, err := time.ParseDuration(rDelay)
+		if err != nil {
+			return policy, fmt.Errorf("%s must be a duration such as 100ms, got %q", name, rDelay)
+		}
+		*delay = d
+	}
+	return policy, nil
+}
+
+// breakerPolicyFromEnv -
+// The default breaker policy, with BREAKER_FAILURE_THRESHOLD and
+// BREAKER_COOL_DOWN overriding it when set.
+func breakerPolicyFromEnv() (weather.BreakerPolicy, error) {
+	policy := weather.DefaultBreakerPolicy
+	if rThreshold := os.Getenv("BREAKER_FAILURE_THRESHOLD"); rThreshold != "" {
+		threshold, err := strconv.Atoi(rThreshold)
+		if err != nil {
+			return policy, fmt.Errorf("BREAKER_FAILURE_THRESHOLD must be an integer, got %q", rThreshold)
+		}
+		policy.FailureThreshold = threshold
+	}
+	if rCoolDown := os.Getenv("BREAKER_COOL_DOWN"); rCoolDown != "" {
+		d, err := time.ParseDuration(rCoolDown)
+		if err != nil {
+			return policy, fmt.Errorf("BREAKER_COOL_DOWN must be a duration such as 30s, got %q", rCoolDown)
+		}
+		policy.CoolDown = d
+	}
+	return policy, nil
+}
+
+// Free tier limits, used unless prefix_PER_MINUTE or prefix_MONTHLY are set
+var (
+	openWeatherLimits  = weather.LimitPolicy{PerMinute: 60, Monthly: 1000000}
+	weatherStackLimits = weather.LimitPolicy{Monthly: 250}
+)
+
+// limitPolicyFromEnv -
+// Start from policy, overriding the prefix_PER_MINUTE and prefix_MONTHLY
+// limits that have been set, zero removes a limit.
+func limitPolicyFromEnv(prefix string, policy weather.LimitPolicy) (weather.LimitPolicy, error) {
+	limits := map[string]*int{
+		prefix + "_PER_MINUTE": &policy.PerMinute,
+		prefix + "_MONTHLY":    &policy.Monthly,
+	}
+	for name, limit := range limits {
+		rLimit := os.Getenv(name)
+		if rLimit == "" {
+			continue
+		}
+		n, err := strconv.Atoi(rLimit)
+		if err != nil {
+			return policy, fmt.Errorf("%s must be an integer, got %q", name, rLimit)
+		}
+		*limit = n
+	}
+	return policy, nil
+}
+
+func strategyFromEnv(name string) (weather.Strategy, error) {
+	switch name {
+	case "", "sequential":
+		return weather.Sequential(), nil
+	case "hedged":
+		return weather.Hedged(hedgeDelay), nil
+	case "first":
+		return weather.FirstSuccess(), nil
+	case "all":
+		return weather.All(weather.Mean), nil
+	case "consensus":
+		return weather.All(weather.Consensus(weather.ConsensusOptions{
+			Method:               weather.Median,
+			TemperatureTolerance: consensusTemperatureTolerance,
+			WindSpeedTolerance:   consensusWindSpeedTolerance,
+		})), nil
+	}
+	return nil, fmt.Errorf("PROVIDER_STRATEGY must be one of sequential, hedged, first, all, or consensus, got %q", name)
+}
+
 func main() {
 	rPort, ok := os.LookupEnv("HTTP_PORT")
 	if !ok {
@@ -36,7 +191,12 @@ func main() {
 	if !ok {
 		log.Fatal("OPENWEATHER app id required")
 	}
-	ow, err := openweathermap.NewOpenWeather(owID)
+	owOpts := []openweathermap.Option{openweathermap.WithUserAgent(userAgent)}
+	// eg. a local stub server
+	if u := os.Getenv("OPENWEATHER_URL"); u != "" {
+		owOpts = append(owOpts, openweathermap.WithBaseURL(u))
+	}
+	ow, err := openweathermap.NewOpenWeather(owID, owOpts...)
 	if err != nil {
 		log.Fatalf("Unable to create new openweathermap provider instance, with error: %v", err)
 	}
@@ -46,13 +206,128 @@ func main() {
 	if !ok {
 		log.Fatal("WEATHERSTACK access key required")
 	}
-	ws, err := weatherstack.NewWeatherStack(wsKey)
+	wsOpts := []weatherstack.Option{weatherstack.WithUserAgent(userAgent)}
+	if u := os.Getenv("WEATHERSTACK_URL"); u != "" {
+		wsOpts = append(wsOpts, weatherstack.WithBaseURL(u))
+	}
+	ws, err := weatherstack.NewWeatherStack(wsKey, wsOpts...)
 	if err != nil {
 		log.Fatalf("Unable to create new weatherstack provider instance, with error: %v", err)
 	}
 
-	//
-	w, err := weather.New([]weather.Provider{ow, ws})
+	// Providers keep their keys out of errors, this catches any that slip
+	// through into the logs
+	log.SetOutput(weather.RedactWriter(os.Stderr, owID, wsKey))
+
+	// How the providers are queried, defaults to trying them in order
+	strategy, err := strategyFromEnv(os.Getenv("PROVIDER_STRATEGY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Supported locations, the bundled list unless a file is supplied
+	registry := weather.DefaultRegistry()
+	if path := os.Getenv("LOCATIONS_FILE"); path != "" {
+		if registry, err = weather.LoadRegistry(path); err != nil {
+			log.Fatalf("Unable to load locations, with error: %v", err)
+		}
+	}
+
+	// Place names searched by /v1/locations, the bundled extract unless a
+	// GeoNames dump is supplied
+	gazetteer := weather.DefaultGazetteer()
+	if path := os.Getenv("GAZETTEER_FILE"); path != "" {
+		if gazetteer, err = weather.LoadGeoNames(path); err != nil {
+			log.Fatalf("Unable to load gazetteer, with error: %v", err)
+		}
+	}
+
+	// Cache
+	policy, err := cachePolicyFromEnv("CACHE", weather.DefaultCachePolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	forecastPolicy, err := cachePolicyFromEnv("FORECAST_CACHE", weather.DefaultForecastCachePolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cacheSize := defaultCacheSize
+	// docker compose passes unset variables through as empty strings
+	if rSize := os.Getenv("CACHE_SIZE"); rSize != "" {
+		if cacheSize, err = strconv.Atoi(rSize); err != nil {
+			log.Fatal("CACHE_SIZE must be an integer")
+		}
+	}
+	cache, err := weather.NewLRUCache(cacheSize)
+	if err != nil {
+		log.Fatalf("Unable to create cache, with error: %v", err)
+	}
+
+	// Calls made to each provider this month, kept across restarts
+	quotaFile := os.Getenv("QUOTA_FILE")
+	if quotaFile == "" {
+		quotaFile = "quota.json"
+	}
+	quota, err := weather.LoadQuota(quotaFile)
+	if err != nil {
+		log.Fatalf("Unable to load quota, with error: %v", err)
+	}
+	owLimits, err := limitPolicyFromEnv("OPENWEATHER", openWeatherLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wsLimits, err := limitPolicyFromEnv("WEATHERSTACK", weatherStackLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Bound each upstream call so a slow provider cannot hold a request open
+	// indefinitely, keep within each provider's limits, retry calls that fail
+	// for a moment, and stop calling a provider that keeps failing. Retries
+	// go through the limiter, so that every call made counts.
+	retryPolicy, err := retryPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	breakerPolicy, err := breakerPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	providers := []weather.Provider{}
+	breakers := []*weather.Breaker{}
+	limiters := []*weather.Limiter{}
+	for _, p := range []struct {
+		weather.Provider
+		limits weather.LimitPolicy
+	}{{ow, owLimits}, {ws, wsLimits}} {
+		limiter, err := weather.WithLimit(weather.WithTimeout(p.Provider, providerTimeout), p.limits, quota)
+		if err != nil {
+			log.Fatalf("Unable to limit %T, with error: %v", p.Provider, err)
+		}
+		retried, err := weather.WithRetry(limiter, retryPolicy)
+		if err != nil {
+			log.Fatalf("Unable to retry %T, with error: %v", p.Provider, err)
+		}
+		// the retries get a deadline of their own, as the calls they make
+		// are not tied to any one caller's
+		breaker, err := weather.WithBreaker(weather.WithTimeout(retried, retryTimeout), breakerPolicy)
+		if err != nil {
+			log.Fatalf("Unable to create circuit breaker for %T, with error: %v", p.Provider, err)
+		}
+		providers = append(providers, breaker)
+		breakers = append(breakers, breaker)
+		limiters = append(limiters, limiter)
+	}
+	expvar.Publish("breakers", weather.BreakerMetrics(breakers...))
+	expvar.Publish("limits", weather.LimitMetrics(limiters...))
+	w, err := weather.New(providers,
+		weather.WithStrategy(strategy),
+		weather.WithCache(cache),
+		weather.WithCachePolicy(policy),
+		weather.WithForecastCachePolicy(forecastPolicy),
+		weather.WithRegistry(registry),
+		weather.WithGazetteer(gazetteer),
+	)
 	if err != nil {
 		log.Fatalf("Unable to create new weather instance, with error: %v", err)
 	}
@@ -60,18 +335,78 @@ func main() {
 	mux := http.NewServeMux()
 	// Routes - note, in a more complex application routes would go into a
 	// dedicated file
-	mux.Handle("/v1/weather", http.HandlerFunc(w.Weather))
+	mux.HandleFunc("/v1/weather", w.Weather)
+	mux.HandleFunc("/v1/weather/batch", w.Batch)
+	mux.HandleFunc("/v1/forecast", w.Forecast)
+	mux.HandleFunc("/v1/history", w.History)
+	mux.HandleFunc("/v1/locations", w.Locations)
+
+	// Clients, the API needs a key unless it is explicitly opened to anyone.
+	// The key is checked before the routes, so nothing on this listener is
+	// served without one
+	anonymous := false
+	if rAnonymous := os.Getenv("ALLOW_ANONYMOUS"); rAnonymous != "" {
+		anonymous, err = strconv.ParseBool(rAnonymous)
+		if err != nil {
+			log.Fatalf("ALLOW_ANONYMOUS must be true or false, got %q", rAnonymous)
+		}
+	}
+	var public http.Handler = mux
+	switch path := os.Getenv("API_KEYS_FILE"); {
+	case path != "":
+		keys, err := weather.LoadAPIKeys(path)
+		if err != nil {
+			log.Fatalf("Unable to load API keys, with error: %v", err)
+		}
+		public = weather.RequireAPIKey(keys, mux)
+	case anonymous:
+		log.Print("WARNING: ALLOW_ANONYMOUS is set and there is no API_KEYS_FILE, the API is open to anyone without a key")
+	default:
+		log.Fatal("API_KEYS_FILE is required, set ALLOW_ANONYMOUS=true to serve the API without keys")
+	}
+
+	// Operations - not for the public, so they have their own listener
+	admin := http.NewServeMux()
+	admin.Handle("/admin/breakers", weather.BreakerAdmin(breakers...))
+	admin.Handle("/admin/limits", weather.LimitAdmin(limiters...))
+	admin.Handle("/debug/vars", expvar.Handler())
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = defaultAdminAddr
+	}
+	if _, _, err := net.SplitHostPort(adminAddr); err != nil {
+		log.Fatalf("ADMIN_ADDR must be a host and port such as %s, got %q", defaultAdminAddr, adminAddr)
+	}
+	adminServer := &http.Server{
+		Addr:    adminAddr,
+		Handler: admin,
+	}
+
+	// Requests derive their context from baseCtx, cancelling it abandons any
+	// upstream calls still in flight
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
 
 	ip := "0.0.0.0"
-	server := &http.Server{Addr: ip + ":" + rPort, Handler: mux}
+	server := &http.Server{
+		Addr:        ip + ":" + rPort,
+		Handler:     public,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
 
-	// Server listens on its own goroutine
+	// Servers listen on their own goroutines, until they are shut down
 	go func() {
 		log.Printf("Listening on %s:%s...", ip, rPort)
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Panicf("Listen and serve returned error: %v", err)
 		}
 	}()
+	go func() {
+		log.Printf("Operations listening on %s...", adminAddr)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Panicf("Operations listen and serve returned error: %v", err)
+		}
+	}()
 
 	// Graceful shutdown!
 
@@ -84,7 +419,11 @@ func main() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if err := adminServer.Shutdown(ctx); err != nil {
+		log.Printf("operations server shutdown returned error %v", err)
+	}
 	if err := server.Shutdown(ctx); err != nil {
+		cancelBase()
 		log.Fatalf("server shutdown returned error %v", err)
 	}
 }
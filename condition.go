@@ -0,0 +1,33 @@
+package weather
+
+// ConditionCode -
+// The sky and any precipitation, shared by every provider so that conditions
+// can be compared whichever provider reported them.
+type ConditionCode string
+
+// Conditions, from clear skies to the most severe
+const (
+	ConditionUnknown      ConditionCode = "unknown"
+	ConditionClear        ConditionCode = "clear"
+	ConditionPartlyCloudy ConditionCode = "partly_cloudy"
+	ConditionCloudy       ConditionCode = "cloudy"
+	ConditionOvercast     ConditionCode = "overcast"
+	ConditionHaze         ConditionCode = "haze"
+	ConditionFog          ConditionCode = "fog"
+	ConditionDrizzle      ConditionCode = "drizzle"
+	ConditionRain         ConditionCode = "rain"
+	ConditionHeavyRain    ConditionCode = "heavy_rain"
+	ConditionSleet        ConditionCode = "sleet"
+	ConditionSnow         ConditionCode = "snow"
+	ConditionThunderstorm ConditionCode = "thunderstorm"
+	ConditionSquall       ConditionCode = "squall"
+	ConditionTornado      ConditionCode = "tornado"
+)
+
+// Condition -
+// What the weather is like. Text is the provider's own description, eg.
+// "broken clouds", which is kept as it is often more specific than Code.
+type Condition struct {
+	Code ConditionCode `json:"code"`
+	Text string        `json:"text,omitempty"`
+}
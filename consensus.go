@@ -0,0 +1,197 @@
+package weather
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ConsensusMethod -
+// How the accepted observations are combined into a single value.
+type ConsensusMethod string
+
+// Supported consensus methods
+const (
+	Median       ConsensusMethod = "median"
+	WeightedMean ConsensusMethod = "weighted_mean"
+)
+
+// ConsensusOptions -
+type ConsensusOptions struct {
+	// Method defaults to Median
+	Method ConsensusMethod
+	// Weights by provider name for WeightedMean, providers without a weight
+	// count as 1
+	Weights map[string]float64
+	// An observation further than the tolerance from the median of all the
+	// observations is rejected as an outlier, zero disables the check. It
+	// takes at least three observations to tell which one is out of line, so
+	// fewer are never rejected
+	TemperatureTolerance float64
+	WindSpeedTolerance   float64
+}
+
+// minOutlierObservations -
+// The fewest comparable observations that outliers are looked for in.
+const minOutlierObservations = 3
+
+// ConsensusReport -
+// How a consensus observation was reached, included in the response.
+type ConsensusReport struct {
+	Method       ConsensusMethod `json:"method"`
+	Contributors []string        `json:"contributors"`
+	// Rejected providers disagreed with the others by more than the
+	// tolerance, or reported in different units
+	Rejected          []string `json:"rejected,omitempty"`
+	TemperatureSpread float64  `json:"temperature_spread"`
+	WindSpeedSpread   float64  `json:"wind_speed_spread"`
+}
+
+// Consensus -
+// Aggregator that compares the providers with each other, rejects outliers,
+// and combines the remaining observations using the configured method. The
+// optional measurements are combined the same way, see Mean.
+// Use it with the All strategy.
+func Consensus(opts ConsensusOptions) Aggregator {
+	if opts.Method == "" {
+		opts.Method = Median
+	}
+	return func(obs []Observation) (Observation, error) {
+		return consensus(opts, obs)
+	}
+}
+
+func consensus(opts ConsensusOptions, obs []Observation) (Observation, error) {
+	if len(obs) == 0 {
+		return Observation{}, fmt.Errorf("no observations to aggregate")
+	}
+	report := &ConsensusReport{Method: opts.Method}
+
+	// values in different units cannot be compared
+	comparable := []Observation{}
+	for i := range obs {
+		if obs[i].Units != obs[0].Units {
+			report.Rejected = append(report.Rejected, obs[i].Provider)
+			continue
+		}
+		comparable = append(comparable, obs[i])
+	}
+
+	// reject outliers, with two observations the median is halfway between
+	// them so either both are outliers or neither is
+	temps, winds := values(comparable)
+	medianTemp, medianWind := median(temps), median(winds)
+	accepted := []Observation{}
+	for i := range comparable {
+		if len(comparable) >= minOutlierObservations && (outlier(comparable[i].Temperature, medianTemp, opts.TemperatureTolerance) ||
+			outlier(comparable[i].WindSpeed, medianWind, opts.WindSpeedTolerance)) {
+			report.Rejected = append(report.Rejected, comparable[i].Provider)
+			continue
+		}
+		accepted = append(accepted, comparable[i])
+	}
+	if len(accepted) == 0 {
+		return Observation{}, fmt.Errorf("providers disagree beyond tolerance: %s", strings.Join(report.Rejected, ","))
+	}
+
+	out := Observation{Units: accepted[0].Units, Location: accepted[0].Location}
+	temps, winds = values(accepted)
+	var combine func(v []float64, from []int) (float64, error)
+	switch opts.Method {
+	case Median:
+		combine = func(v []float64, _ []int) (float64, error) {
+			return median(v), nil
+		}
+	case WeightedMean:
+		weights := make([]float64, len(accepted))
+		for i := range accepted {
+			weights[i] = 1
+			if w, ok := opts.Weights[accepted[i].Provider]; ok {
+				weights[i] = w
+			}
+		}
+		combine = func(v []float64, from []int) (float64, error) {
+			w := make([]float64, len(from))
+			for i := range from {
+				w[i] = weights[from[i]]
+			}
+			return weightedMean(v, w)
+		}
+	default:
+		return Observation{}, fmt.Errorf("unknown consensus method %q", opts.Method)
+	}
+	every := make([]int, len(accepted))
+	for i := range every {
+		every[i] = i
+	}
+	var err error
+	if out.Temperature, err = combine(temps, every); err != nil {
+		return Observation{}, err
+	}
+	if out.WindSpeed, err = combine(winds, every); err != nil {
+		return Observation{}, err
+	}
+	combineDetails(&out, accepted, combine)
+
+	for i := range accepted {
+		report.Contributors = append(report.Contributors, accepted[i].Provider)
+		if accepted[i].ObservedAt.After(out.ObservedAt) {
+			out.ObservedAt = accepted[i].ObservedAt
+		}
+	}
+	report.TemperatureSpread = spread(temps)
+	report.WindSpeedSpread = spread(winds)
+	out.Provider = strings.Join(report.Contributors, ",")
+	out.Consensus = report
+	return out, nil
+}
+
+func values(obs []Observation) (temps, winds []float64) {
+	for i := range obs {
+		temps = append(temps, obs[i].Temperature)
+		winds = append(winds, obs[i].WindSpeed)
+	}
+	return temps, winds
+}
+
+func outlier(v, median, tolerance float64) bool {
+	return tolerance > 0 && math.Abs(v-median) > tolerance
+}
+
+func median(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	s := append([]float64{}, v...)
+	sort.Float64s(s)
+	mid := len(s) / 2
+	if len(s)%2 == 0 {
+		return (s[mid-1] + s[mid]) / 2
+	}
+	return s[mid]
+}
+
+func weightedMean(v, weights []float64) (float64, error) {
+	var sum, total float64
+	for i := range v {
+		sum += v[i] * weights[i]
+		total += weights[i]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("weights must add up to more than zero")
+	}
+	return sum / total, nil
+}
+
+func spread(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	lo, hi := v[0], v[0]
+	for i := range v {
+		lo = math.Min(lo, v[i])
+		hi = math.Max(hi, v[i])
+	}
+	return hi - lo
+}
@@ -0,0 +1,141 @@
+package weather_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsensus(t *testing.T) {
+	metric := weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond}
+	f := func(v float64) *float64 { return &v }
+	obs := []weather.Observation{
+		{Provider: "a", Temperature: 15, WindSpeed: 3, Units: metric},
+		{Provider: "b", Temperature: 16, WindSpeed: 4, Units: metric},
+		{Provider: "c", Temperature: 40, WindSpeed: 5, Units: metric},
+	}
+
+	testcases := map[string]struct {
+		opts     weather.ConsensusOptions
+		obs      []weather.Observation
+		expected weather.Observation
+		err      error
+	}{
+		"median": {
+			obs: obs,
+			expected: weather.Observation{
+				Provider: "a,b,c", Temperature: 16, WindSpeed: 4, Units: metric,
+				Consensus: &weather.ConsensusReport{
+					Method:            weather.Median,
+					Contributors:      []string{"a", "b", "c"},
+					TemperatureSpread: 25,
+					WindSpeedSpread:   2,
+				},
+			},
+		},
+		"median rejects outlier": {
+			opts: weather.ConsensusOptions{TemperatureTolerance: 5},
+			obs:  obs,
+			expected: weather.Observation{
+				Provider: "a,b", Temperature: 15.5, WindSpeed: 3.5, Units: metric,
+				Consensus: &weather.ConsensusReport{
+					Method:            weather.Median,
+					Contributors:      []string{"a", "b"},
+					Rejected:          []string{"c"},
+					TemperatureSpread: 1,
+					WindSpeedSpread:   1,
+				},
+			},
+		},
+		"weighted mean": {
+			opts: weather.ConsensusOptions{
+				Method:               weather.WeightedMean,
+				Weights:              map[string]float64{"a": 3},
+				TemperatureTolerance: 5,
+			},
+			obs: obs,
+			expected: weather.Observation{
+				Provider: "a,b", Temperature: 15.25, WindSpeed: 3.25, Units: metric,
+				Consensus: &weather.ConsensusReport{
+					Method:            weather.WeightedMean,
+					Contributors:      []string{"a", "b"},
+					Rejected:          []string{"c"},
+					TemperatureSpread: 1,
+					WindSpeedSpread:   1,
+				},
+			},
+		},
+		"different units are rejected": {
+			obs: []weather.Observation{
+				{Provider: "a", Temperature: 15, WindSpeed: 3, Units: metric},
+				{Provider: "b", Temperature: 15, WindSpeed: 36, Units: weather.Units{Temperature: weather.Celsius, WindSpeed: weather.KilometresPerHour}},
+			},
+			expected: weather.Observation{
+				Provider: "a", Temperature: 15, WindSpeed: 3, Units: metric,
+				Consensus: &weather.ConsensusReport{
+					Method:       weather.Median,
+					Contributors: []string{"a"},
+					Rejected:     []string{"b"},
+				},
+			},
+		},
+		"two providers are never outliers": {
+			opts: weather.ConsensusOptions{TemperatureTolerance: 1},
+			obs:  obs[1:],
+			expected: weather.Observation{
+				Provider: "b,c", Temperature: 28, WindSpeed: 4.5, Units: metric,
+				Consensus: &weather.ConsensusReport{
+					Method:            weather.Median,
+					Contributors:      []string{"b", "c"},
+					TemperatureSpread: 24,
+					WindSpeedSpread:   1,
+				},
+			},
+		},
+		"no agreement": {
+			opts: weather.ConsensusOptions{TemperatureTolerance: 1},
+			obs:  append([]weather.Observation{{Provider: "d", Temperature: 30, WindSpeed: 3, Units: metric}}, obs...),
+			err:  fmt.Errorf("providers disagree beyond tolerance: d,a,b,c"),
+		},
+		"unknown method": {
+			opts: weather.ConsensusOptions{Method: "mode"},
+			obs:  obs,
+			err:  fmt.Errorf("unknown consensus method \"mode\""),
+		},
+		"optional measurements": {
+			opts: weather.ConsensusOptions{Method: weather.WeightedMean, Weights: map[string]float64{"a": 3}, TemperatureTolerance: 5},
+			obs: []weather.Observation{
+				{Provider: "a", Temperature: 15, WindSpeed: 3, Units: metric, Humidity: f(40), Condition: &weather.Condition{Code: weather.ConditionClear}},
+				{Provider: "b", Temperature: 16, WindSpeed: 4, Units: metric, Humidity: f(80), Pressure: f(1012), Condition: &weather.Condition{Code: weather.ConditionCloudy}},
+				{Provider: "c", Temperature: 40, WindSpeed: 5, Units: metric, Humidity: f(10), Pressure: f(900)},
+			},
+			expected: weather.Observation{
+				Provider: "a,b", Temperature: 15.25, WindSpeed: 3.25, Units: metric,
+				Humidity: f(50), Pressure: f(1012), Condition: &weather.Condition{Code: weather.ConditionClear},
+				Consensus: &weather.ConsensusReport{
+					Method:            weather.WeightedMean,
+					Contributors:      []string{"a", "b"},
+					Rejected:          []string{"c"},
+					TemperatureSpread: 1,
+					WindSpeedSpread:   1,
+				},
+			},
+		},
+		"nothing to aggregate": {
+			err: fmt.Errorf("no observations to aggregate"),
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			output, err := weather.Consensus(tc.opts)(tc.obs)
+			if tc.err == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.expected, output)
+			} else {
+				assert.EqualError(t, err, tc.err.Error())
+			}
+		})
+	}
+}
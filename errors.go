@@ -0,0 +1,246 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of provider failure, providers wrap these so that the service can tell
+// them apart with errors.Is
+var (
+	// ErrAuth - the provider rejected the credentials it was given
+	ErrAuth = errors.New("invalid credentials")
+	// ErrQuotaExceeded - the provider will not answer more requests until the
+	// quota is renewed, eg. next month
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrRateLimited - the provider is being asked too often, and will answer
+	// again shortly
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnknownLocation - the provider does not know the location asked for
+	ErrUnknownLocation = errors.New("unknown location")
+	// ErrUnavailable - the provider is down or failing
+	ErrUnavailable = errors.New("upstream unavailable")
+	// ErrTemporary - the call failed in a way that may not happen again, eg. a
+	// dropped connection
+	ErrTemporary = errors.New("temporary failure")
+)
+
+// MarkTemporary -
+// err, also reported as ErrTemporary by errors.Is. err is still unwrapped, so
+// eg. a context.DeadlineExceeded inside it can still be found.
+func MarkTemporary(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &temporaryError{err: err}
+}
+
+type temporaryError struct {
+	err error
+}
+
+// Error -
+func (t *temporaryError) Error() string {
+	return t.err.Error()
+}
+
+// Unwrap -
+func (t *temporaryError) Unwrap() error {
+	return t.err
+}
+
+// Is -
+func (t *temporaryError) Is(target error) bool {
+	return target == ErrTemporary
+}
+
+// IsPermanent -
+// Whether asking the same provider again, soon, cannot give a different
+// answer. Permanent errors should not be retried, other errors may be, after
+// waiting for RetryAfter when it is given.
+func IsPermanent(err error) bool {
+	for _, kind := range []error{ErrAuth, ErrQuotaExceeded, ErrUnknownLocation, ErrNotSupported} {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTemporary -
+// Whether err is the provider being out of reach, rather than giving a bad
+// answer.
+func isTemporary(err error) bool {
+	for _, kind := range []error{context.DeadlineExceeded, context.Canceled, ErrTemporary, ErrUnavailable, ErrRateLimited, ErrQuotaExceeded, ErrCircuitOpen} {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryAfter -
+// How long a provider asked to be left alone for, eg. with a Retry-After
+// header. Provider errors give the wait with a method
+//
+//	RetryAfter() (time.Duration, bool)
+//
+// reporting false when the provider did not say.
+func RetryAfter(err error) (time.Duration, bool) {
+	var r interface {
+		RetryAfter() (time.Duration, bool)
+	}
+	if !errors.As(err, &r) {
+		return 0, false
+	}
+	return r.RetryAfter()
+}
+
+// ParseRetryAfter -
+// The wait given by a Retry-After header, either as seconds or as an HTTP
+// date, see RFC 7231 section 7.1.3.
+func ParseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// ProviderError -
+// The failure of a single named provider.
+type ProviderError struct {
+	Provider string
+	Err      error
+}
+
+// Error -
+func (p *ProviderError) Error() string {
+	return fmt.Sprintf("%s: %v", p.Provider, p.Err)
+}
+
+// Unwrap -
+func (p *ProviderError) Unwrap() error {
+	return p.Err
+}
+
+// AllFailedError -
+// Returned when every provider has been tried and none of them succeeded.
+type AllFailedError struct {
+	Failures []*ProviderError
+}
+
+// Error -
+func (a *AllFailedError) Error() string {
+	msgs := make([]string, len(a.Failures))
+	for i := range a.Failures {
+		msgs[i] = a.Failures[i].Error()
+	}
+	return "all providers failed: " + strings.Join(msgs, "; ")
+}
+
+// Status -
+// The HTTP status that best describes the failure. When every provider said
+// the location does not exist it is not found, when every provider was out of
+// reach, eg. timed out or rate limited, the service is unavailable, otherwise
+// the upstreams gave bad answers.
+func (a *AllFailedError) Status() int {
+	if len(a.Failures) == 0 {
+		return http.StatusServiceUnavailable
+	}
+	if a.every(func(err error) bool { return errors.Is(err, ErrUnknownLocation) }) {
+		return http.StatusNotFound
+	}
+	if a.every(isTemporary) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// RetryAfter -
+// How long until a provider should be able to answer, when the service is
+// unavailable and every provider said how long to wait.
+func (a *AllFailedError) RetryAfter() (time.Duration, bool) {
+	if a.Status() != http.StatusServiceUnavailable || len(a.Failures) == 0 {
+		return 0, false
+	}
+	soonest := time.Duration(0)
+	for i := range a.Failures {
+		wait, ok := RetryAfter(a.Failures[i].Err)
+		if !ok {
+			return 0, false
+		}
+		if i == 0 || wait < soonest {
+			soonest = wait
+		}
+	}
+	return soonest, true
+}
+
+func (a *AllFailedError) every(match func(error) bool) bool {
+	for i := range a.Failures {
+		if !match(a.Failures[i].Err) {
+			return false
+		}
+	}
+	return true
+}
+
+// errorResponse -
+// JSON body sent to the client when no weather can be returned.
+type errorResponse struct {
+	Error     string            `json:"error"`
+	Providers []providerFailure `json:"providers,omitempty"`
+}
+
+type providerFailure struct {
+	Provider string `json:"provider"`
+	Error    string `json:"error"`
+}
+
+func newErrorResponse(a *AllFailedError) errorResponse {
+	resp := errorResponse{Error: "no provider was able to supply the weather"}
+	for i := range a.Failures {
+		resp.Providers = append(resp.Providers, providerFailure{
+			Provider: a.Failures[i].Provider,
+			Error:    a.Failures[i].Err.Error(),
+		})
+	}
+	return resp
+}
+
+// writeFailure -
+// Send resp for failed, with a Retry-After header when every provider said
+// how long to wait.
+func writeFailure(w http.ResponseWriter, failed *AllFailedError, resp errorResponse) {
+	if wait, ok := failed.RetryAfter(); ok {
+		w.Header().Set("Retry-After", headerSeconds(wait))
+	}
+	writeJSON(w, failed.Status(), resp)
+}
+
+// headerSeconds -
+// d in whole seconds for a header, rounded up so that a client waiting that
+// long does not come back too soon.
+func headerSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
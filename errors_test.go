@@ -0,0 +1,141 @@
+package weather_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitError is a provider error that says how long to wait
+type waitError struct {
+	kind error
+	wait time.Duration
+}
+
+func (w *waitError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", w.kind, w.wait)
+}
+
+func (w *waitError) Unwrap() error {
+	return w.kind
+}
+
+func (w *waitError) RetryAfter() (time.Duration, bool) {
+	return w.wait, true
+}
+
+func TestAllFailedErrorStatus(t *testing.T) {
+	testcases := map[string]struct {
+		errs       []error
+		status     int
+		retryAfter time.Duration // zero when there is no wait
+	}{
+		"no providers": {
+			status: http.StatusServiceUnavailable,
+		},
+		"bad answers": {
+			errs:   []error{fmt.Errorf("fake error"), fmt.Errorf("fake %w", weather.ErrAuth)},
+			status: http.StatusBadGateway,
+		},
+		"every provider timed out": {
+			errs:   []error{context.DeadlineExceeded, fmt.Errorf("fake %w", context.DeadlineExceeded)},
+			status: http.StatusServiceUnavailable,
+		},
+		"every provider out of reach": {
+			errs:   []error{weather.MarkTemporary(fmt.Errorf("connection reset")), weather.ErrUnavailable, weather.ErrQuotaExceeded},
+			status: http.StatusServiceUnavailable,
+		},
+		"every provider rate limited": {
+			errs:       []error{&waitError{kind: weather.ErrRateLimited, wait: time.Minute}, &waitError{kind: weather.ErrRateLimited, wait: 30 * time.Second}},
+			status:     http.StatusServiceUnavailable,
+			retryAfter: 30 * time.Second,
+		},
+		"one provider did not say how long": {
+			errs:   []error{&waitError{kind: weather.ErrRateLimited, wait: time.Minute}, weather.ErrRateLimited},
+			status: http.StatusServiceUnavailable,
+		},
+		"every provider does not know the location": {
+			errs:   []error{fmt.Errorf("city not found: %w", weather.ErrUnknownLocation), weather.ErrUnknownLocation},
+			status: http.StatusNotFound,
+		},
+		"one provider does not know the location": {
+			errs:   []error{weather.ErrUnknownLocation, fmt.Errorf("fake error")},
+			status: http.StatusBadGateway,
+		},
+		"one provider timed out": {
+			errs:   []error{context.DeadlineExceeded, fmt.Errorf("fake error")},
+			status: http.StatusBadGateway,
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			failed := &weather.AllFailedError{}
+			for i, err := range tc.errs {
+				failed.Failures = append(failed.Failures, &weather.ProviderError{Provider: fmt.Sprint(i), Err: err})
+			}
+			assert.Equal(t, tc.status, failed.Status())
+			wait, ok := failed.RetryAfter()
+			assert.Equal(t, tc.retryAfter != 0, ok)
+			assert.Equal(t, tc.retryAfter, wait)
+		})
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	testcases := map[string]struct {
+		err       error
+		permanent bool
+	}{
+		"auth":             {err: fmt.Errorf("fake %w", weather.ErrAuth), permanent: true},
+		"quota":            {err: weather.ErrQuotaExceeded, permanent: true},
+		"unknown location": {err: weather.ErrUnknownLocation, permanent: true},
+		"not supported":    {err: weather.ErrNotSupported, permanent: true},
+		"rate limited":     {err: weather.ErrRateLimited},
+		"unavailable":      {err: weather.ErrUnavailable},
+		"temporary":        {err: weather.MarkTemporary(fmt.Errorf("connection reset"))},
+		"unknown":          {err: fmt.Errorf("fake error")},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.permanent, weather.IsPermanent(tc.err))
+		})
+	}
+}
+
+func TestMarkTemporary(t *testing.T) {
+	assert.Nil(t, weather.MarkTemporary(nil))
+
+	err := weather.MarkTemporary(fmt.Errorf("dial: %w", context.DeadlineExceeded))
+	assert.EqualError(t, err, "dial: context deadline exceeded")
+	assert.ErrorIs(t, err, weather.ErrTemporary)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	testcases := map[string]struct {
+		header string
+		wait   time.Duration
+		ok     bool
+	}{
+		"missing":      {},
+		"seconds":      {header: "120", wait: 2 * time.Minute, ok: true},
+		"negative":     {header: "-1"},
+		"date":         {header: "Thu, 11 Nov 2021 07:01:30 GMT", wait: 90 * time.Second, ok: true},
+		"date passed":  {header: "Thu, 11 Nov 2021 06:00:00 GMT", ok: true},
+		"not a wait":   {header: "soon"},
+		"padded value": {header: " 5 ", wait: 5 * time.Second, ok: true},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			wait, ok := weather.ParseRetryAfter(tc.header, now)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.wait, wait)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// observationFields -
+// The JSON names of every Observation field, which are the names accepted by
+// the fields selector.
+var observationFields = jsonNames(reflect.TypeOf(Observation{}))
+
+func jsonNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseFields -
+// The fields asked for in a comma separated list, eg.
+// "temperature_degrees,humidity", or nil when the list is empty.
+func parseFields(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	fields := []string{}
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		if !observationFields[f] {
+			return nil, fmt.Errorf("Bad Request, unknown field %q", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// selectFields -
+// Only the fields of o that were asked for, and stale, so that a client can
+// never mistake an old observation for a new one. Fields that o has no value
+// for are left out.
+func selectFields(o Observation, fields []string) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	selected := map[string]json.RawMessage{}
+	for _, f := range append(fields, "stale") {
+		if v, ok := all[f]; ok {
+			selected[f] = v
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,81 @@
+package weather
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeatherFields(t *testing.T) {
+	humidity := 58.0
+	testcases := map[string]struct {
+		fields  string
+		fakeErr error
+		status  int
+		body    string
+	}{
+		"selected": {
+			fields: "temperature_degrees, humidity,condition",
+			status: http.StatusOK,
+			body:   `{"temperature_degrees":15.5,"humidity":58,"condition":{"code":"cloudy","text":"broken clouds"}}`,
+		},
+		"missing values are left out": {
+			fields: "wind_gust,units",
+			status: http.StatusOK,
+			body:   `{"units":{"temperature":"celsius","wind_speed":"m/s"}}`,
+		},
+		"stale is always kept": {
+			fields:  "temperature_degrees",
+			fakeErr: fmt.Errorf("fake error"),
+			status:  http.StatusOK,
+			body:    `{"temperature_degrees":10,"stale":true}`,
+		},
+		"every field": {
+			status: http.StatusOK,
+			body: `{"temperature_degrees":15.5,"wind_speed":2.5,"units":{"temperature":"celsius","wind_speed":"m/s"},
+				"observed_at":"0001-01-01T00:00:00Z","provider":"fake","location":"Melbourne","humidity":58,
+				"condition":{"code":"cloudy","text":"broken clouds"}}`,
+		},
+		"unknown field": {
+			fields: "temperature_degrees,dew_point",
+			status: http.StatusBadRequest,
+			body:   "Bad Request, unknown field \"dew_point\"\n",
+		},
+	}
+	defer func() { timeNow = time.Now }()
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			o, err := New([]Provider{&fakeProvider{}})
+			assert.Nil(t, err)
+			now := time.Now()
+			timeNow = func() time.Time { return now }
+			o.cache.Set("melbourne", Entry{Value: Observation{Temperature: 10}, Stored: now.Add(-time.Hour)})
+			fakeResponse = Observation{
+				Temperature: 15.5,
+				WindSpeed:   2.5,
+				Units:       Units{Temperature: Celsius, WindSpeed: MetresPerSecond},
+				Provider:    "fake",
+				Location:    "Melbourne",
+				Humidity:    &humidity,
+				Condition:   &Condition{Code: ConditionCloudy, Text: "broken clouds"},
+			}
+			fakeResponseErr = tc.fakeErr
+
+			req, err := http.NewRequest(http.MethodGet, "/v1/weather?city=melbourne&fields="+tc.fields, nil)
+			assert.Nil(t, err)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(o.Weather).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.status == http.StatusOK {
+				assert.JSONEq(t, tc.body, rr.Body.String())
+				return
+			}
+			assert.Equal(t, tc.body, rr.Body.String())
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package weather
+
+import (
+	"context"
+	"sync"
+)
+
+// group -
+// Coalesces concurrent lookups for the same key into a single call, while
+// lookups for different keys proceed in parallel. Values are whatever the
+// lookup returns, eg. an Observation or a Forecast.
+type group struct {
+	m     sync.Mutex
+	calls map[string]*flight
+}
+
+// flight -
+// A call in progress and the callers waiting on it.
+type flight struct {
+	done    chan struct{}
+	val     interface{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// fetchFunc -
+// Looks up a value, eg. from the providers.
+type fetchFunc func(ctx context.Context) (interface{}, error)
+
+func newGroup() *group {
+	return &group{calls: map[string]*flight{}}
+}
+
+// Do -
+// Run fn for key, or wait for the call already running for key, and return
+// its result.
+// fn is not tied to any single caller's context, it is only cancelled once
+// every caller waiting on it has given up, so one client disconnecting does
+// not fail the lookup for everyone else.
+func (g *group) Do(ctx context.Context, key string, fn fetchFunc) (interface{}, error) {
+	g.m.Lock()
+	f, ok := g.calls[key]
+	if !ok {
+		fctx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = f
+		go g.run(fctx, key, f, fn)
+	}
+	f.waiters++
+	g.m.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		g.m.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// nobody is waiting, later callers must start a fresh call
+			f.cancel()
+			g.forget(key, f)
+		}
+		g.m.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *group) run(ctx context.Context, key string, f *flight, fn fetchFunc) {
+	f.val, f.err = fn(ctx)
+	g.m.Lock()
+	g.forget(key, f)
+	g.m.Unlock()
+	f.cancel()
+	close(f.done)
+}
+
+// forget -
+// Remove f from the group, unless it has already been replaced by a newer
+// call. Must be called with g.m held.
+func (g *group) forget(key string, f *flight) {
+	if g.calls[key] == f {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,137 @@
+package weather
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// awaitWaiters -
+// Wait until n callers are waiting on the flight for key.
+func awaitWaiters(g *group, key string, n int) {
+	waiters := func() int {
+		g.m.Lock()
+		defer g.m.Unlock()
+		if f, ok := g.calls[key]; ok {
+			return f.waiters
+		}
+		return 0
+	}
+	for waiters() < n {
+		runtime.Gosched()
+	}
+}
+
+func TestGroupCoalescesSameKey(t *testing.T) {
+	g := newGroup()
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return Observation{Temperature: 10}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := g.Do(context.Background(), "melbourne", fn)
+			assert.Nil(t, err)
+			assert.Equal(t, float64(10), val.(Observation).Temperature)
+		}()
+	}
+	// let every caller join the flight before it finishes
+	awaitWaiters(g, "melbourne", 10)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestGroupDifferentKeysRunInParallel(t *testing.T) {
+	g := newGroup()
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return Observation{}, nil
+	}
+
+	go func() { _, _ = g.Do(context.Background(), "melbourne", fn) }()
+	go func() { _, _ = g.Do(context.Background(), "sydney", fn) }()
+
+	// both lookups must start without waiting on each other
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("lookup for a different key was blocked")
+		}
+	}
+	close(release)
+}
+
+func TestGroupCancellation(t *testing.T) {
+	testcases := map[string]struct {
+		cancelAll bool
+	}{
+		"one caller leaving does not cancel the lookup": {},
+		"every caller leaving cancels the lookup":       {cancelAll: true},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			g := newGroup()
+			cancelled := make(chan struct{})
+			// a second flight would otherwise close cancelled twice
+			var once sync.Once
+			release := make(chan struct{})
+			fn := func(ctx context.Context) (interface{}, error) {
+				select {
+				case <-ctx.Done():
+					once.Do(func() { close(cancelled) })
+					return nil, ctx.Err()
+				case <-release:
+					return Observation{Temperature: 10}, nil
+				}
+			}
+
+			leaving, leave := context.WithCancel(context.Background())
+			staying, stay := context.WithCancel(context.Background())
+			defer stay()
+			results := make(chan error, 2)
+			go func() {
+				_, err := g.Do(leaving, "melbourne", fn)
+				results <- err
+			}()
+			awaitWaiters(g, "melbourne", 1)
+			go func() {
+				_, err := g.Do(staying, "melbourne", fn)
+				results <- err
+			}()
+			awaitWaiters(g, "melbourne", 2)
+
+			leave()
+			assert.Equal(t, context.Canceled, <-results)
+			if tc.cancelAll {
+				stay()
+				assert.Equal(t, context.Canceled, <-results)
+				select {
+				case <-cancelled:
+				case <-time.After(time.Second):
+					t.Fatal("lookup was not cancelled")
+				}
+				return
+			}
+			close(release)
+			assert.Nil(t, <-results)
+		})
+	}
+}
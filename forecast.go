@@ -0,0 +1,308 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shanehowearth/weather/units"
+)
+
+// Forecaster -
+// Optionally implemented by a Provider that can also forecast the weather.
+// Wrappers, eg. WithTimeout, implement it whatever they wrap, and report
+// ErrNotSupported when the provider they wrap cannot forecast.
+type Forecaster interface {
+	// GetForecastContext returns at least the next hours of forecast where
+	// the upstream has them
+	GetForecastContext(ctx context.Context, loc Location, hours int) (Forecast, error)
+}
+
+// ErrNotSupported -
+// A provider was asked for something it cannot supply.
+var ErrNotSupported = errors.New("not supported by this provider")
+
+// Forecast -
+// The weather expected at a location, oldest period first.
+type Forecast struct {
+	// Hourly holds each period the provider forecasts, eg. every three hours
+	// for OpenWeatherMap
+	Hourly []ForecastPeriod `json:"hourly"`
+	// Daily is rolled up from Hourly by the service, in the local time of the
+	// location where it is known, otherwise UTC
+	Daily    []DailyForecast `json:"daily,omitempty"`
+	Units    Units           `json:"units"`
+	Provider string          `json:"provider"`
+	Location string          `json:"location"`
+	// Stale is set when every provider failed and a previous forecast is
+	// being returned instead
+	Stale bool `json:"stale,omitempty"`
+}
+
+// ForecastPeriod -
+// The weather expected from Time until the next period.
+type ForecastPeriod struct {
+	Time        time.Time `json:"time"`
+	Temperature float64   `json:"temperature_degrees"`
+	WindSpeed   float64   `json:"wind_speed"`
+	// WindGust is 0 when the provider does not forecast gusts
+	WindGust float64 `json:"wind_gust"`
+}
+
+// DailyForecast -
+// The extremes of the periods forecast for a day.
+type DailyForecast struct {
+	// Date is the local date, eg. 2021-11-12
+	Date           string  `json:"date"`
+	MinTemperature float64 `json:"min_temperature_degrees"`
+	MaxTemperature float64 `json:"max_temperature_degrees"`
+	// MaxWindGust is never less than the strongest wind speed forecast,
+	// gusts are at least as strong as the wind. It is left out when the
+	// provider has no gusts for the day
+	MaxWindGust float64 `json:"max_wind_gust,omitempty"`
+}
+
+// In -
+// f with its temperatures and speeds converted to sys.
+func (f Forecast) In(sys units.System) (Forecast, error) {
+	c := converter{from: f.Units, to: sys}
+	f.Hourly = c.periods(f.Hourly)
+	f.Daily = c.days(f.Daily)
+	if c.err != nil {
+		return Forecast{}, c.err
+	}
+	f.Units = c.units()
+	return f, nil
+}
+
+// periods -
+// A converted copy of periods.
+func (c *converter) periods(periods []ForecastPeriod) []ForecastPeriod {
+	if periods == nil {
+		return nil
+	}
+	out := make([]ForecastPeriod, len(periods))
+	for i, p := range periods {
+		c.temperature(&p.Temperature)
+		c.speed(&p.WindSpeed)
+		c.speed(&p.WindGust)
+		out[i] = p
+	}
+	return out
+}
+
+// days -
+// A converted copy of days.
+func (c *converter) days(days []DailyForecast) []DailyForecast {
+	if days == nil {
+		return nil
+	}
+	out := make([]DailyForecast, len(days))
+	for i, d := range days {
+		c.temperature(&d.MinTemperature)
+		c.temperature(&d.MaxTemperature)
+		c.speed(&d.MaxWindGust)
+		out[i] = d
+	}
+	return out
+}
+
+// DefaultForecastCachePolicy -
+// Forecasts are only issued every few hours, so are kept for longer than
+// observations.
+var DefaultForecastCachePolicy = CachePolicy{
+	TTL:          30 * time.Minute,
+	StaleIfError: 6 * time.Hour,
+}
+
+// WithForecastCachePolicy -
+// Choose how long cached forecasts are used for, the default is
+// DefaultForecastCachePolicy.
+func WithForecastCachePolicy(p CachePolicy) Option {
+	return func(d *data) {
+		d.forecastPolicy = p
+	}
+}
+
+// Limits on the hours forecast
+const (
+	defaultForecastHours = 24
+	// OpenWeatherMap forecasts five days ahead
+	maxForecastHours = 120
+)
+
+// Forecast -
+// The forecast for the next hours, eg. /v1/forecast?city=melbourne&hours=48
+// The location is given in any of the forms accepted by /v1/weather.
+func (d *data) Forecast(w http.ResponseWriter, r *http.Request) {
+	// only GET allowed
+	if r.Method != http.MethodGet {
+		http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hours := defaultForecastHours
+	if rHours := r.URL.Query().Get("hours"); rHours != "" {
+		h, err := strconv.Atoi(rHours)
+		if err != nil || h < 1 || h > maxForecastHours {
+			http.Error(w, fmt.Sprintf("Bad Request, hours must be between 1 and %d", maxForecastHours), http.StatusBadRequest)
+			return
+		}
+		hours = h
+	}
+	loc, err := d.resolve(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sys, err := requestedUnits(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the whole forecast is cached, and cut down to the hours asked for
+	v, stale, err := d.cachedLookup(r.Context(), forecastKey(loc.ID), d.forecastPolicy, isForecast, d.forecastFetcher(loc))
+	if err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			http.Error(w, "No provider is able to forecast", http.StatusNotImplemented)
+			return
+		}
+		failed, ok := err.(*AllFailedError)
+		if !ok {
+			log.Printf("ERROR forecast lookup for %q: %v", loc.ID, err)
+			failed = &AllFailedError{}
+		}
+		resp := newErrorResponse(failed)
+		resp.Error = "no provider was able to supply the forecast"
+		writeFailure(w, failed, resp)
+		return
+	}
+	f := v.(Forecast)
+	f.Stale = stale
+	if stale {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	f.Hourly = upcoming(f.Hourly, timeNow(), hours)
+	f.Daily = rollup(f.Hourly, timezone(loc))
+	if f, err = f.In(sys); err != nil {
+		log.Printf("unable to convert forecast for %q to %v, with error %v", loc.ID, sys, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+// forecastKey -
+// Forecasts share the cache with observations under their own keys.
+func forecastKey(id LocationID) string {
+	return "forecast:" + id.String()
+}
+
+func isForecast(v interface{}) bool {
+	_, ok := v.(Forecast)
+	return ok
+}
+
+// forecastFetcher -
+// Ask each provider that can forecast in turn, and cache the first forecast.
+func (d *data) forecastFetcher(loc Location) fetchFunc {
+	return func(ctx context.Context) (interface{}, error) {
+		val, err := d.inTurn(func(p Provider) (interface{}, error) {
+			f, ok := p.(Forecaster)
+			if !ok {
+				return nil, ErrNotSupported
+			}
+			fc, err := f.GetForecastContext(ctx, loc, maxForecastHours)
+			if err != nil {
+				return nil, err
+			}
+			return fc.In(units.Canonical)
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.cache.Set(forecastKey(loc.ID), Entry{Value: val, Stored: timeNow()})
+		return val, nil
+	}
+}
+
+// inTurn -
+// Call ask with each provider until one succeeds. Providers that report
+// ErrNotSupported are skipped, and ErrNotSupported is returned when every
+// provider does.
+func (d *data) inTurn(ask func(p Provider) (interface{}, error)) (interface{}, error) {
+	failed := &AllFailedError{}
+	for _, p := range d.providers {
+		val, err := ask(p)
+		if errors.Is(err, ErrNotSupported) {
+			continue
+		}
+		if err != nil {
+			failed.Failures = append(failed.Failures, failure(p, err))
+			continue
+		}
+		return val, nil
+	}
+	if len(failed.Failures) == 0 {
+		return nil, ErrNotSupported
+	}
+	return nil, failed
+}
+
+// upcoming -
+// The periods from the start of the current hour until hours from now.
+func upcoming(periods []ForecastPeriod, now time.Time, hours int) []ForecastPeriod {
+	from := now.Truncate(time.Hour)
+	until := now.Add(time.Duration(hours) * time.Hour)
+	kept := []ForecastPeriod{}
+	for _, p := range periods {
+		if !p.Time.Before(from) && p.Time.Before(until) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// timezone -
+// Where the location's days start and end, UTC when that is unknown.
+func timezone(loc Location) *time.Location {
+	if loc.Timezone == "" {
+		return time.UTC
+	}
+	tz, err := time.LoadLocation(loc.Timezone)
+	if err != nil {
+		log.Printf("ERROR unknown timezone %q for %q: %v", loc.Timezone, loc.ID, err)
+		return time.UTC
+	}
+	return tz
+}
+
+// rollup -
+// Summarise the periods for each day in tz, in order.
+func rollup(periods []ForecastPeriod, tz *time.Location) []DailyForecast {
+	days := []DailyForecast{}
+	for _, p := range periods {
+		date := p.Time.In(tz).Format("2006-01-02")
+		gust := math.Max(p.WindGust, p.WindSpeed)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, DailyForecast{
+				Date:           date,
+				MinTemperature: p.Temperature,
+				MaxTemperature: p.Temperature,
+				MaxWindGust:    gust,
+			})
+			continue
+		}
+		day := &days[len(days)-1]
+		day.MinTemperature = math.Min(day.MinTemperature, p.Temperature)
+		day.MaxTemperature = math.Max(day.MaxTemperature, p.Temperature)
+		day.MaxWindGust = math.Max(day.MaxWindGust, gust)
+	}
+	return days
+}
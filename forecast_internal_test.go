@@ -0,0 +1,190 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeForecaster forecasts every three hours from the start of the day
+type fakeForecaster struct {
+	fakeProvider
+	calls int
+	err   error
+}
+
+func (f *fakeForecaster) GetForecastContext(ctx context.Context, loc Location, hours int) (Forecast, error) {
+	f.calls++
+	if f.err != nil {
+		return Forecast{}, f.err
+	}
+	start := time.Date(2021, 11, 12, 0, 0, 0, 0, time.UTC)
+	fc := Forecast{Provider: "fake", Location: loc.Name}
+	for i := 0; i < 16; i++ {
+		fc.Hourly = append(fc.Hourly, ForecastPeriod{
+			Time:        start.Add(time.Duration(3*i) * time.Hour),
+			Temperature: float64(10 + i),
+			WindSpeed:   float64(i),
+			WindGust:    float64(2 * i),
+		})
+	}
+	return fc, nil
+}
+
+func TestForecastHandler(t *testing.T) {
+	now := time.Date(2021, 11, 12, 10, 30, 0, 0, time.UTC)
+	testcases := map[string]struct {
+		query     string
+		method    string
+		providers []Provider
+		cached    bool
+		fakeErr   error
+		status    int
+		errBody   string
+		hourly    int
+		daily     []DailyForecast
+		stale     bool
+	}{
+		"default hours": {
+			query:  "?lat=0&lon=0",
+			status: http.StatusOK,
+			// 12:00 until 09:00 the next day
+			hourly: 8,
+			daily: []DailyForecast{
+				{Date: "2021-11-12", MinTemperature: 14, MaxTemperature: 17, MaxWindGust: 14},
+				{Date: "2021-11-13", MinTemperature: 18, MaxTemperature: 21, MaxWindGust: 22},
+			},
+		},
+		"days in the location's timezone": {
+			query:  "?city=melbourne&hours=6",
+			status: http.StatusOK,
+			// 12:00 and 15:00 UTC are 23:00 and 02:00 in Melbourne
+			hourly: 2,
+			daily: []DailyForecast{
+				{Date: "2021-11-12", MinTemperature: 14, MaxTemperature: 14, MaxWindGust: 8},
+				{Date: "2021-11-13", MinTemperature: 15, MaxTemperature: 15, MaxWindGust: 10},
+			},
+		},
+		"served from the cache": {
+			query:   "?city=melbourne&hours=3",
+			cached:  true,
+			fakeErr: fmt.Errorf("fake error"),
+			status:  http.StatusOK,
+			hourly:  1,
+			daily:   []DailyForecast{{Date: "2021-11-12", MinTemperature: 14, MaxTemperature: 14, MaxWindGust: 8}},
+		},
+		"every provider fails": {
+			query:   "?city=melbourne",
+			fakeErr: fmt.Errorf("fake error"),
+			status:  http.StatusBadGateway,
+			errBody: `{"error":"no provider was able to supply the forecast","providers":[{"provider":"fake","error":"fake error"}]}`,
+		},
+		"no provider forecasts": {
+			query:     "?city=melbourne",
+			providers: []Provider{&fakeProvider{}, WithTimeout(&fakeProvider{}, time.Second)},
+			status:    http.StatusNotImplemented,
+			errBody:   "No provider is able to forecast\n",
+		},
+		"bad hours": {
+			query:   "?city=melbourne&hours=121",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, hours must be between 1 and 120\n",
+		},
+		"unknown city": {
+			query:   "?city=fake",
+			status:  http.StatusBadRequest,
+			errBody: "Sorry, don't know that city \"fake\"\n",
+		},
+		"bad method": {
+			query:   "?city=melbourne",
+			method:  http.MethodPost,
+			status:  http.StatusMethodNotAllowed,
+			errBody: "Bad method\n",
+		},
+	}
+	defer func() { timeNow = time.Now }()
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			f := &fakeForecaster{err: tc.fakeErr}
+			providers := tc.providers
+			if providers == nil {
+				providers = []Provider{&fakeProvider{}, WithTimeout(f, time.Second)}
+			}
+			o, err := New(providers)
+			assert.Nil(t, err)
+			timeNow = func() time.Time { return now }
+			if tc.cached {
+				fc, _ := (&fakeForecaster{}).GetForecastContext(context.Background(), Location{Name: "Melbourne"}, 24)
+				o.cache.Set(forecastKey("melbourne"), Entry{Value: fc, Stored: now.Add(-time.Minute)})
+			}
+
+			req, err := http.NewRequest(tc.method, "/v1/forecast"+tc.query, nil)
+			assert.Nil(t, err)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(o.Forecast).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.errBody != "" {
+				assert.Equal(t, tc.errBody, rr.Body.String())
+				return
+			}
+			body := Forecast{}
+			assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			assert.Len(t, body.Hourly, tc.hourly)
+			assert.Equal(t, tc.daily, body.Daily)
+			assert.Equal(t, tc.stale, body.Stale)
+			if tc.cached {
+				assert.Equal(t, 0, f.calls)
+			}
+
+			// forecasts are cached apart from observations
+			_, ok := o.cache.Get("melbourne")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestForecastStaleIfError(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2021, 11, 12, 10, 30, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	f := &fakeForecaster{err: fmt.Errorf("fake error")}
+	o, err := New([]Provider{f})
+	assert.Nil(t, err)
+	fc, _ := (&fakeForecaster{}).GetForecastContext(context.Background(), Location{Name: "Melbourne"}, 24)
+	o.cache.Set(forecastKey("melbourne"), Entry{Value: fc, Stored: now.Add(-time.Hour)})
+
+	req, err := http.NewRequest(http.MethodGet, "/v1/forecast?city=melbourne&hours=3", nil)
+	assert.Nil(t, err)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(o.Forecast).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `110 - "Response is Stale"`, rr.Header().Get("Warning"))
+	body := Forecast{}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.True(t, body.Stale)
+	assert.Equal(t, 1, f.calls)
+}
+
+func TestRollup(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2021, 11, 12, h, 0, 0, 0, time.UTC) }
+	days := rollup([]ForecastPeriod{
+		{Time: at(10), Temperature: 10, WindSpeed: 5, WindGust: 9},
+		{Time: at(18), Temperature: -2, WindSpeed: 12},
+		{Time: at(21), Temperature: 6, WindSpeed: 3, WindGust: 4},
+	}, time.FixedZone("", 5*60*60))
+	assert.Equal(t, []DailyForecast{
+		// gusts are never weaker than the wind
+		{Date: "2021-11-12", MinTemperature: -2, MaxTemperature: 10, MaxWindGust: 12},
+		{Date: "2021-11-13", MinTemperature: 6, MaxTemperature: 6, MaxWindGust: 4},
+	}, days)
+	assert.Equal(t, []DailyForecast{}, rollup(nil, time.UTC))
+}
@@ -0,0 +1,302 @@
+package weather
+
+import (
+	"bufio"
+	"bytes"
+	// embed the bundled gazetteer
+	_ "embed"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Gazetteer -
+// An offline index of place names, searched by prefix and by edit distance so
+// that misspelt names still find a match.
+type Gazetteer struct {
+	entries []gazetteerEntry
+}
+
+type gazetteerEntry struct {
+	loc Location
+	// every canonical name the location is known by
+	names []LocationID
+	// population is used to rank otherwise equal matches
+	population int64
+}
+
+// Match -
+// A location found by a search, and how far the query was from its name.
+type Match struct {
+	Location Location `json:"location"`
+	// Distance is the number of single character edits between the query and
+	// the matched name, 0 for a prefix match
+	Distance int `json:"distance"`
+}
+
+//go:embed gazetteer.tsv
+var defaultGazetteer []byte
+
+// DefaultGazetteer -
+// The gazetteer bundled with the service, a small GeoNames extract.
+func DefaultGazetteer() *Gazetteer {
+	g, err := ReadGeoNames(bytes.NewReader(defaultGazetteer))
+	if err != nil {
+		// the bundled file is checked by the tests, so this cannot happen
+		panic(fmt.Sprintf("bundled gazetteer is invalid: %v", err))
+	}
+	return g
+}
+
+// NewGazetteer -
+// Index locations, eg. those in a Registry, by their name and ID.
+func NewGazetteer(locs []Location) *Gazetteer {
+	g := &Gazetteer{}
+	for _, l := range locs {
+		g.entries = append(g.entries, gazetteerEntry{
+			loc:   l,
+			names: []LocationID{NewLocationID(l.Name), l.ID},
+		})
+	}
+	return g
+}
+
+// LoadGeoNames -
+// Read a GeoNames format dump, eg. cities15000.txt from
+// https://download.geonames.org/export/dump/, from disk.
+func LoadGeoNames(path string) (*Gazetteer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("loadGeoNames: opening %s error %w", path, err)
+	}
+	defer f.Close()
+	g, err := ReadGeoNames(f)
+	if err != nil {
+		return nil, fmt.Errorf("loadGeoNames: %s %w", path, err)
+	}
+	return g, nil
+}
+
+// Columns of the GeoNames main table that are used
+const (
+	geoID             = 0
+	geoName           = 1
+	geoASCIIName      = 2
+	geoAlternateNames = 3
+	geoLat            = 4
+	geoLon            = 5
+	geoCountry        = 8
+	geoAdmin1         = 10
+	geoPopulation     = 14
+	geoTimezone       = 17
+	geoColumns        = 19
+)
+
+// ReadGeoNames -
+// Parse the tab separated GeoNames main table. Places are searchable by their
+// ASCII and alternate names as well as their name, and are ranked by
+// population.
+// The region is the GeoNames admin1 code, eg. "07" for Victoria.
+func ReadGeoNames(r io.Reader) (*Gazetteer, error) {
+	g := &Gazetteer{}
+	scanner := bufio.NewScanner(r)
+	// alternate names make some rows very long
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cols := strings.Split(text, "\t")
+		if len(cols) < geoColumns {
+			return nil, fmt.Errorf("line %d: expected %d columns, got %d", line, geoColumns, len(cols))
+		}
+		lat, err := strconv.ParseFloat(cols[geoLat], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad latitude %q", line, cols[geoLat])
+		}
+		lon, err := strconv.ParseFloat(cols[geoLon], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad longitude %q", line, cols[geoLon])
+		}
+		// population is optional
+		population, _ := strconv.ParseInt(cols[geoPopulation], 10, 64)
+
+		e := gazetteerEntry{
+			loc: Location{
+				ID:       LocationID("geonames:" + cols[geoID]),
+				Name:     cols[geoName],
+				Country:  cols[geoCountry],
+				Region:   cols[geoAdmin1],
+				Lat:      lat,
+				Lon:      lon,
+				Timezone: cols[geoTimezone],
+			},
+			names:      []LocationID{NewLocationID(cols[geoName])},
+			population: population,
+		}
+		for _, a := range append([]string{cols[geoASCIIName]}, strings.Split(cols[geoAlternateNames], ",")...) {
+			if a != "" {
+				e.names = append(e.names, NewLocationID(a))
+			}
+		}
+		g.entries = append(g.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Search -
+// Find up to limit locations whose name starts with q, or is within
+// maxDistance edits of q, closest first. Locations in other countries are
+// skipped when country is set, and in other regions when region is set.
+func (g *Gazetteer) Search(q, country, region string, maxDistance, limit int) []Match {
+	query := NewLocationID(q).String()
+	if query == "" {
+		return []Match{}
+	}
+	country = strings.ToUpper(strings.TrimSpace(country))
+	region = NewLocationID(region).String()
+
+	type ranked struct {
+		Match
+		population int64
+	}
+	found := []ranked{}
+	for _, e := range g.entries {
+		if country != "" && e.loc.Country != country {
+			continue
+		}
+		if region != "" && NewLocationID(e.loc.Region).String() != region {
+			continue
+		}
+		best := -1
+		for _, name := range e.names {
+			d := nameDistance(query, name.String(), maxDistance)
+			if d >= 0 && (best < 0 || d < best) {
+				best = d
+			}
+		}
+		if best >= 0 {
+			found = append(found, ranked{Match{Location: e.loc, Distance: best}, e.population})
+		}
+	}
+
+	// closest first, then the biggest place, then alphabetically
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Distance != found[j].Distance {
+			return found[i].Distance < found[j].Distance
+		}
+		if found[i].population != found[j].population {
+			return found[i].population > found[j].population
+		}
+		return found[i].Location.Name < found[j].Location.Name
+	})
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	matches := make([]Match, len(found))
+	for i := range found {
+		matches[i] = found[i].Match
+	}
+	return matches
+}
+
+// nameDistance -
+// 0 when name starts with query, otherwise the edit distance between them, or
+// -1 when that is more than maxDistance.
+func nameDistance(query, name string, maxDistance int) int {
+	if strings.HasPrefix(name, query) {
+		return 0
+	}
+	// the distance is at least the difference in length
+	if diff := len([]rune(name)) - len([]rune(query)); diff > maxDistance || -diff > maxDistance {
+		return -1
+	}
+	d := levenshtein(query, name)
+	if d > maxDistance {
+		return -1
+	}
+	return d
+}
+
+// levenshtein -
+// The number of single rune insertions, deletions, or substitutions needed to
+// turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Defaults for the locations endpoint
+const (
+	defaultSearchLimit    = 10
+	maxSearchLimit        = 100
+	defaultSearchDistance = 2
+)
+
+// Locations -
+// Search the gazetteer, eg. /v1/locations?q=melb&country=AU&region=07&limit=5
+func (d *data) Locations(w http.ResponseWriter, r *http.Request) {
+	// only GET allowed
+	if r.Method != http.MethodGet {
+		http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := query.Get("q")
+	if strings.TrimSpace(q) == "" {
+		http.Error(w, "Bad Request, q is required", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if rLimit := query.Get("limit"); rLimit != "" {
+		l, err := strconv.Atoi(rLimit)
+		if err != nil || l < 1 || l > maxSearchLimit {
+			http.Error(w, fmt.Sprintf("Bad Request, limit must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Matches []Match `json:"matches"`
+	}{
+		Matches: d.gazetteer.Search(q, query.Get("country"), query.Get("region"), defaultSearchDistance, limit),
+	})
+}
@@ -0,0 +1,82 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevenshtein(t *testing.T) {
+	testcases := map[string]struct {
+		a, b     string
+		expected int
+	}{
+		"same":         {a: "melbourne", b: "melbourne", expected: 0},
+		"substitution": {a: "sydnee", b: "sydney", expected: 1},
+		"deletion":     {a: "melborne", b: "melbourne", expected: 1},
+		"transposed":   {a: "pertg", b: "perth", expected: 1},
+		"empty":        {a: "", b: "perth", expected: 5},
+		"runes":        {a: "zürich", b: "zurich", expected: 1},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, levenshtein(tc.a, tc.b))
+			assert.Equal(t, tc.expected, levenshtein(tc.b, tc.a))
+		})
+	}
+}
+
+func TestLocationsHandler(t *testing.T) {
+	testcases := map[string]struct {
+		query  string
+		method string
+		status int
+		body   string
+	}{
+		"search": {
+			query:  "?q=melton&country=au",
+			status: http.StatusOK,
+			body:   `{"matches":[{"location":{"id":"geonames:2158651","name":"Melton","country":"AU","region":"07","lat":-37.68333,"lon":144.58333,"timezone":"Australia/Melbourne"},"distance":0}]}`,
+		},
+		"no matches": {
+			query:  "?q=atlantis",
+			status: http.StatusOK,
+			body:   `{"matches":[]}`,
+		},
+		"bad method": {
+			query:  "?q=melb",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+			body:   "Bad method\n",
+		},
+		"no query": {
+			status: http.StatusBadRequest,
+			body:   "Bad Request, q is required\n",
+		},
+		"bad limit": {
+			query:  "?q=melb&limit=1000",
+			status: http.StatusBadRequest,
+			body:   "Bad Request, limit must be between 1 and 100\n",
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			o, err := New([]Provider{&fakeProvider{}})
+			assert.Nil(t, err)
+
+			req, err := http.NewRequest(tc.method, "/v1/locations"+tc.query, nil)
+			assert.Nil(t, err)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(o.Locations).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.status == http.StatusOK {
+				assert.JSONEq(t, tc.body, rr.Body.String())
+				return
+			}
+			assert.Equal(t, tc.body, rr.Body.String())
+		})
+	}
+}
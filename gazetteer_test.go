@@ -0,0 +1,105 @@
+package weather_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGazetteerSearch(t *testing.T) {
+	g := weather.DefaultGazetteer()
+
+	testcases := map[string]struct {
+		q, country, region string
+		distance, limit    int
+		expected           []string
+	}{
+		"prefix ranked by population": {q: "melb", distance: 2, expected: []string{"Melbourne, AU", "Melbourne, US"}},
+		"prefix in country":           {q: "Melb", country: "us", distance: 2, expected: []string{"Melbourne, US"}},
+		"prefix in region":            {q: "mel", country: "AU", region: "07", distance: 2, expected: []string{"Melbourne, AU", "Melton, AU"}},
+		"misspelt":                    {q: "Melborne", country: "AU", distance: 2, expected: []string{"Melbourne, AU"}},
+		"too far":                     {q: "Melborne", country: "AU", distance: 0, expected: []string{}},
+		"alternate name":              {q: "Narrm", distance: 2, expected: []string{"Melbourne, AU"}},
+		"limit":                       {q: "melb", distance: 2, limit: 1, expected: []string{"Melbourne, AU"}},
+		"closest first":               {q: "Sydny", distance: 2, expected: []string{"Sydney, AU"}},
+		"empty":                       {q: " ", distance: 2, expected: []string{}},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			found := []string{}
+			for _, m := range g.Search(tc.q, tc.country, tc.region, tc.distance, tc.limit) {
+				found = append(found, m.Location.Name+", "+m.Location.Country)
+			}
+			assert.Equal(t, tc.expected, found)
+		})
+	}
+}
+
+func TestReadGeoNames(t *testing.T) {
+	row := func(cols ...string) string {
+		return strings.Join(cols, "\t") + "\n"
+	}
+	brisbane := row("2174003", "Brisbane", "Brisbane", "Meanjin", "-27.46794", "153.02809", "P", "PPLA", "AU", "", "04", "", "", "", "2189878", "", "28", "Australia/Brisbane", "2019-07-18")
+
+	testcases := map[string]struct {
+		input string
+		err   string
+	}{
+		"valid":         {input: "# comment\n\n" + brisbane},
+		"short row":     {input: "2174003\tBrisbane\n", err: "line 1: expected 19 columns, got 2"},
+		"bad latitude":  {input: row("1", "Nowhere", "", "", "north", "0", "", "", "AU", "", "", "", "", "", "", "", "", "", ""), err: `line 1: bad latitude "north"`},
+		"bad longitude": {input: row("1", "Nowhere", "", "", "0", "east", "", "", "AU", "", "", "", "", "", "", "", "", "", ""), err: `line 1: bad longitude "east"`},
+		"no population": {input: row("1", "Nowhere", "", "", "0", "0", "", "", "AU", "", "", "", "", "", "", "", "", "", "")},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			g, err := weather.ReadGeoNames(strings.NewReader(tc.input))
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Len(t, g.Search("", "", "", 0, 0), 0)
+		})
+	}
+
+	g, err := weather.ReadGeoNames(strings.NewReader(brisbane))
+	assert.Nil(t, err)
+	matches := g.Search("meanjin", "", "", 0, 0)
+	assert.Equal(t, []weather.Match{{Location: weather.Location{
+		ID:       "geonames:2174003",
+		Name:     "Brisbane",
+		Country:  "AU",
+		Region:   "04",
+		Lat:      -27.46794,
+		Lon:      153.02809,
+		Timezone: "Australia/Brisbane",
+	}}}, matches)
+}
+
+func TestLoadGeoNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gazetteer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cities.txt")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("1\tNowhere\t\t\t0\t0\t\t\tAU\t\t\t\t\t\t\t\t\t\t\n"), 0o600))
+	g, err := weather.LoadGeoNames(path)
+	assert.Nil(t, err)
+	assert.Len(t, g.Search("nowhere", "", "", 0, 0), 1)
+
+	_, err = weather.LoadGeoNames(filepath.Join(dir, "missing.txt"))
+	assert.NotNil(t, err)
+}
+
+func TestNewGazetteer(t *testing.T) {
+	g := weather.NewGazetteer(weather.DefaultRegistry().Locations())
+	matches := g.Search("sydnee", "", "", 2, 0)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, weather.LocationID("sydney"), matches[0].Location.ID)
+}
@@ -2,4 +2,8 @@ module github.com/shanehowearth/weather
 
 go 1.16
 
-require github.com/stretchr/testify v1.7.0
+require (
+	github.com/stretchr/testify v1.7.0
+	golang.org/x/text v0.3.7
+	gopkg.in/yaml.v3 v3.0.1
+)
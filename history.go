@@ -0,0 +1,202 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/shanehowearth/weather/units"
+)
+
+// HistoryProvider -
+// Optionally implemented by a Provider that can report the weather on a past
+// date. Wrappers, eg. WithTimeout, implement it whatever they wrap, and report
+// ErrNotSupported when the provider they wrap has no history.
+type HistoryProvider interface {
+	// GetHistoryContext returns the periods recorded on date, which is
+	// midnight at the start of the day in the location's timezone
+	GetHistoryContext(ctx context.Context, loc Location, date time.Time) (History, error)
+}
+
+// History -
+// The weather recorded at a location on a past day, oldest period first.
+type History struct {
+	// Date is the local date, eg. 2021-11-10
+	Date string `json:"date"`
+	// Hourly uses the same periods as a forecast
+	Hourly []ForecastPeriod `json:"hourly"`
+	// Summary is rolled up from Hourly by the service, or reported by a
+	// provider with no hourly periods
+	Summary  *DailyForecast `json:"summary,omitempty"`
+	Units    Units          `json:"units"`
+	Provider string         `json:"provider"`
+	Location string         `json:"location"`
+	// UTCOffset is the location's offset from UTC on the date, in seconds,
+	// when the provider knows it. It places the local day for a location
+	// without a timezone, eg. one looked up by coordinates
+	UTCOffset *int `json:"-"`
+}
+
+// In -
+// h with its temperatures and speeds converted to sys.
+func (h History) In(sys units.System) (History, error) {
+	c := converter{from: h.Units, to: sys}
+	h.Hourly = c.periods(h.Hourly)
+	if h.Summary != nil {
+		h.Summary = &c.days([]DailyForecast{*h.Summary})[0]
+	}
+	if c.err != nil {
+		return History{}, c.err
+	}
+	h.Units = c.units()
+	return h, nil
+}
+
+// historyPolicy -
+// The past does not change, so history is never looked up again while it is
+// still in the cache. It shares the cache with observations, so it can still
+// be evicted to make room.
+var historyPolicy = CachePolicy{TTL: time.Duration(math.MaxInt64)}
+
+// History -
+// The weather on a past date, eg. /v1/history?city=melbourne&date=2021-11-10
+// The location is given in any of the forms accepted by /v1/weather, and the
+// date is the local date at the location.
+func (d *data) History(w http.ResponseWriter, r *http.Request) {
+	// only GET allowed
+	if r.Method != http.MethodGet {
+		http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loc, err := d.resolve(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sys, err := requestedUnits(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tz := timezone(loc)
+	date, err := time.ParseInLocation("2006-01-02", r.URL.Query().Get("date"), tz)
+	if err != nil {
+		http.Error(w, "Bad Request, date must be a date such as 2021-11-10", http.StatusBadRequest)
+		return
+	}
+	// only finished days are history, and can be kept until evicted
+	now := timeNow().In(tz)
+	if !date.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tz)) {
+		http.Error(w, "Bad Request, date must be before today", http.StatusBadRequest)
+		return
+	}
+
+	v, _, err := d.cachedLookup(r.Context(), historyKey(loc.ID, date), historyPolicy, isHistory, d.historyFetcher(loc, date))
+	if err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			http.Error(w, "No provider is able to supply history", http.StatusNotImplemented)
+			return
+		}
+		failed, ok := err.(*AllFailedError)
+		if !ok {
+			log.Printf("ERROR history lookup for %q: %v", loc.ID, err)
+			failed = &AllFailedError{}
+		}
+		resp := newErrorResponse(failed)
+		resp.Error = "no provider was able to supply the history"
+		writeFailure(w, failed, resp)
+		return
+	}
+	h, err := v.(History).In(sys)
+	if err != nil {
+		log.Printf("unable to convert history for %q to %v, with error %v", loc.ID, sys, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// historyKey -
+// History shares the cache with observations under its own keys.
+func historyKey(id LocationID, date time.Time) string {
+	return "history:" + id.String() + ":" + date.Format("2006-01-02")
+}
+
+func isHistory(v interface{}) bool {
+	_, ok := v.(History)
+	return ok
+}
+
+// historyFetcher -
+// Ask each provider with history in turn. Only the periods on the local date
+// are kept, with their summary, and cached. A provider with nothing for the
+// date has failed, and the next one is asked, so an empty day is never
+// cached.
+func (d *data) historyFetcher(loc Location, date time.Time) fetchFunc {
+	return func(ctx context.Context) (interface{}, error) {
+		val, err := d.inTurn(func(p Provider) (interface{}, error) {
+			h, ok := p.(HistoryProvider)
+			if !ok {
+				return nil, ErrNotSupported
+			}
+			past, err := h.GetHistoryContext(ctx, loc, date)
+			if err != nil {
+				return nil, err
+			}
+			if past, err = past.In(units.Canonical); err != nil {
+				return nil, err
+			}
+			return onDate(past, loc, date)
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.cache.Set(historyKey(loc.ID, date), Entry{Value: val, Stored: timeNow()})
+		return val, nil
+	}
+}
+
+// onDate -
+// h with only the periods on the local date, starting at midnight date, and
+// their summary. A summary from the provider is kept when it has no periods.
+// Only a whole day is returned, as it is then cached.
+func onDate(h History, loc Location, date time.Time) (History, error) {
+	h.Date = date.Format("2006-01-02")
+	// without a timezone date is midnight UTC, the provider's offset says
+	// where the local day really falls
+	if loc.Timezone == "" {
+		switch {
+		case h.UTCOffset != nil:
+			date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.FixedZone("", *h.UTCOffset))
+		case len(h.Hourly) > 0:
+			return History{}, fmt.Errorf("no timezone to find %s in", h.Date)
+		}
+	}
+	until := date.AddDate(0, 0, 1)
+	// eg. west of UTC, the UTC date can end before the local one
+	if timeNow().Before(until) {
+		return History{}, fmt.Errorf("%s is not over yet", h.Date)
+	}
+	kept := []ForecastPeriod{}
+	for _, p := range h.Hourly {
+		if !p.Time.Before(date) && p.Time.Before(until) {
+			kept = append(kept, p)
+		}
+	}
+	switch {
+	case len(kept) > 0:
+		days := rollup(kept, date.Location())
+		h.Summary = &days[0]
+	case len(h.Hourly) > 0:
+		return History{}, fmt.Errorf("no periods on %s", h.Date)
+	case h.Summary == nil:
+		return History{}, fmt.Errorf("no history for %s", h.Date)
+	}
+	h.Hourly = kept
+	return h, nil
+}
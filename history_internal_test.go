@@ -0,0 +1,218 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHistorian records every hour of the UTC days either side of date, at
+// offset seconds from UTC, unless it has its own history
+type fakeHistorian struct {
+	fakeProvider
+	calls   int
+	err     error
+	offset  *int
+	history *History
+}
+
+func (f *fakeHistorian) GetHistoryContext(ctx context.Context, loc Location, date time.Time) (History, error) {
+	f.calls++
+	if f.err != nil {
+		return History{}, f.err
+	}
+	if f.history != nil {
+		return *f.history, nil
+	}
+	start := date.UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+	h := History{Provider: "fake", Location: loc.Name, UTCOffset: f.offset}
+	for i := 0; i < 72; i++ {
+		h.Hourly = append(h.Hourly, ForecastPeriod{
+			Time:        start.Add(time.Duration(i) * time.Hour),
+			Temperature: float64(i),
+			WindSpeed:   1,
+		})
+	}
+	return h, nil
+}
+
+func TestHistoryHandler(t *testing.T) {
+	// UTC offsets, in seconds
+	utc, aedt, sst := 0, 11*60*60, -11*60*60
+	now := time.Date(2021, 11, 12, 10, 30, 0, 0, time.UTC)
+	testcases := map[string]struct {
+		query     string
+		method    string
+		providers []Provider
+		fakeErr   error
+		offset    *int
+		history   *History
+		status    int
+		errBody   string
+		hourly    int
+		summary   *DailyForecast
+	}{
+		"utc day": {
+			query:   "?lat=0&lon=0&date=2021-11-10",
+			offset:  &utc,
+			status:  http.StatusOK,
+			hourly:  24,
+			summary: &DailyForecast{Date: "2021-11-10", MinTemperature: 24, MaxTemperature: 47, MaxWindGust: 1},
+		},
+		"local day": {
+			// Melbourne is 11 hours ahead of UTC in November
+			query:   "?city=melbourne&date=2021-11-10",
+			status:  http.StatusOK,
+			hourly:  24,
+			summary: &DailyForecast{Date: "2021-11-10", MinTemperature: 37, MaxTemperature: 60, MaxWindGust: 1},
+		},
+		"coordinates use the provider's offset": {
+			query:   "?lat=-37.814&lon=144.9633&date=2021-11-10",
+			offset:  &aedt,
+			status:  http.StatusOK,
+			hourly:  24,
+			summary: &DailyForecast{Date: "2021-11-10", MinTemperature: 13, MaxTemperature: 36, MaxWindGust: 1},
+		},
+		"coordinates without an offset": {
+			query:   "?lat=-37.814&lon=144.9633&date=2021-11-10",
+			status:  http.StatusBadGateway,
+			errBody: `{"error":"no provider was able to supply the history","providers":[{"provider":"fake","error":"no timezone to find 2021-11-10 in"}]}`,
+		},
+		"local day not over": {
+			// 10:30 UTC is still 2021-11-11 in Pago Pago
+			query:   "?lat=-14.2756&lon=-170.702&date=2021-11-11",
+			offset:  &sst,
+			status:  http.StatusBadGateway,
+			errBody: `{"error":"no provider was able to supply the history","providers":[{"provider":"fake","error":"2021-11-11 is not over yet"}]}`,
+		},
+		"yesterday": {
+			query:   "?lat=0&lon=0&date=2021-11-11",
+			offset:  &utc,
+			status:  http.StatusOK,
+			hourly:  24,
+			summary: &DailyForecast{Date: "2021-11-11", MinTemperature: 24, MaxTemperature: 47, MaxWindGust: 1},
+		},
+		"today": {
+			query:   "?lat=0&lon=0&date=2021-11-12",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, date must be before today\n",
+		},
+		"bad date": {
+			query:   "?city=melbourne&date=10/11/2021",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, date must be a date such as 2021-11-10\n",
+		},
+		"summary without periods": {
+			query: "?city=melbourne&date=2021-11-10",
+			history: &History{
+				Summary: &DailyForecast{Date: "2021-11-10", MinTemperature: 12, MaxTemperature: 21, MaxWindGust: 9},
+				Units:   Units{Temperature: Celsius, WindSpeed: MetresPerSecond},
+			},
+			status:  http.StatusOK,
+			summary: &DailyForecast{Date: "2021-11-10", MinTemperature: 12, MaxTemperature: 21, MaxWindGust: 9},
+		},
+		"nothing recorded": {
+			query:   "?city=melbourne&date=2021-11-10",
+			history: &History{Units: Units{Temperature: Celsius, WindSpeed: MetresPerSecond}},
+			status:  http.StatusBadGateway,
+			errBody: `{"error":"no provider was able to supply the history","providers":[{"provider":"fake","error":"no history for 2021-11-10"}]}`,
+		},
+		"nothing recorded on the date": {
+			query: "?city=melbourne&date=2021-11-10",
+			history: &History{
+				Hourly: []ForecastPeriod{{Time: time.Date(2021, 11, 11, 12, 0, 0, 0, time.UTC), Temperature: 20}},
+				Units:  Units{Temperature: Celsius, WindSpeed: MetresPerSecond},
+			},
+			status:  http.StatusBadGateway,
+			errBody: `{"error":"no provider was able to supply the history","providers":[{"provider":"fake","error":"no periods on 2021-11-10"}]}`,
+		},
+		"every provider fails": {
+			query:   "?city=melbourne&date=2021-11-10",
+			fakeErr: fmt.Errorf("fake error"),
+			status:  http.StatusBadGateway,
+			errBody: `{"error":"no provider was able to supply the history","providers":[{"provider":"fake","error":"fake error"}]}`,
+		},
+		"no provider has history": {
+			query:     "?city=melbourne&date=2021-11-10",
+			providers: []Provider{&fakeProvider{}, WithTimeout(&fakeProvider{}, time.Second)},
+			status:    http.StatusNotImplemented,
+			errBody:   "No provider is able to supply history\n",
+		},
+		"bad method": {
+			query:   "?city=melbourne&date=2021-11-10",
+			method:  http.MethodPost,
+			status:  http.StatusMethodNotAllowed,
+			errBody: "Bad method\n",
+		},
+	}
+	defer func() { timeNow = time.Now }()
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			h := &fakeHistorian{err: tc.fakeErr, offset: tc.offset, history: tc.history}
+			providers := tc.providers
+			if providers == nil {
+				providers = []Provider{&fakeProvider{}, WithTimeout(h, time.Second)}
+			}
+			o, err := New(providers)
+			assert.Nil(t, err)
+			timeNow = func() time.Time { return now }
+
+			req, err := http.NewRequest(tc.method, "/v1/history"+tc.query, nil)
+			assert.Nil(t, err)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(o.History).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.errBody != "" {
+				assert.Equal(t, tc.errBody, rr.Body.String())
+				return
+			}
+			body := History{}
+			assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			assert.Len(t, body.Hourly, tc.hourly)
+			assert.Equal(t, tc.summary, body.Summary)
+			assert.Equal(t, tc.summary.Date, body.Date)
+		})
+	}
+}
+
+func TestHistoryStaysCached(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2021, 11, 12, 10, 30, 0, 0, time.UTC)
+	h := &fakeHistorian{}
+	o, err := New([]Provider{h})
+	assert.Nil(t, err)
+
+	for _, age := range []time.Duration{0, time.Hour, 24 * 365 * time.Hour} {
+		timeNow = func() time.Time { return now.Add(age) }
+		req, err := http.NewRequest(http.MethodGet, "/v1/history?city=melbourne&date=2021-11-10", nil)
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(o.History).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	assert.Equal(t, 1, h.calls)
+}
+
+func TestEmptyHistoryIsNotCached(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Date(2021, 11, 12, 10, 30, 0, 0, time.UTC) }
+	h := &fakeHistorian{history: &History{Units: Units{Temperature: Celsius, WindSpeed: MetresPerSecond}}}
+	o, err := New([]Provider{h})
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "/v1/history?city=melbourne&date=2021-11-10", nil)
+		assert.Nil(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(o.History).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadGateway, rr.Code)
+	}
+	assert.Equal(t, 2, h.calls)
+}
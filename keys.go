@@ -0,0 +1,214 @@
+package weather
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIKey -
+// A client allowed to use the service. Only a hash of the key is kept, so the
+// keys file does not give the keys away.
+type APIKey struct {
+	// Name identifies the client, eg. in logs
+	Name string `json:"name" yaml:"name"`
+	// SHA256 is the hex encoded hash of the key, see HashAPIKey
+	SHA256 string `json:"sha256" yaml:"sha256"`
+	// PerMinute is the average number of requests allowed each minute, which
+	// can all be made at once after a quiet spell, zero means no limit
+	PerMinute int `json:"per_minute,omitempty" yaml:"per_minute,omitempty"`
+	// Daily is the number of requests allowed each UTC day, zero means no
+	// limit
+	Daily int `json:"daily,omitempty" yaml:"daily,omitempty"`
+}
+
+// HashAPIKey -
+// The hash of key kept in the keys file, the same as
+// `printf %s key | sha256sum`.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeys -
+// The clients allowed to use the service, with the requests each has left.
+// Counts are kept in memory, so a restart gives every client a fresh day.
+type APIKeys struct {
+	byHash map[string]*client
+}
+
+// client -
+// A key and the requests made with it.
+type client struct {
+	APIKey
+
+	mu sync.Mutex
+	// nil without a per minute limit
+	bucket *bucket
+	day    string
+	used   int
+}
+
+// NewAPIKeys -
+// Check keys have a name, a well formed hash, and limits that make sense.
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	a := &APIKeys{byHash: map[string]*client{}}
+	now := timeNow()
+	for i := range keys {
+		k := keys[i]
+		k.SHA256 = strings.ToLower(k.SHA256)
+		switch {
+		case k.Name == "":
+			return nil, fmt.Errorf("api key %d has no name", i)
+		case len(k.SHA256) != sha256.Size*2 || strings.Trim(k.SHA256, "0123456789abcdef") != "":
+			return nil, fmt.Errorf("api key %s sha256 must be %d hex digits", k.Name, sha256.Size*2)
+		case k.PerMinute < 0 || k.Daily < 0:
+			return nil, fmt.Errorf("api key %s limits cannot be negative", k.Name)
+		}
+		if other, ok := a.byHash[k.SHA256]; ok {
+			return nil, fmt.Errorf("api keys %s and %s are the same", other.Name, k.Name)
+		}
+		c := &client{APIKey: k, day: quotaDay(now)}
+		if k.PerMinute > 0 {
+			c.bucket = newBucket(k.PerMinute, k.PerMinute, now)
+		}
+		a.byHash[k.SHA256] = c
+	}
+	return a, nil
+}
+
+// ParseAPIKeys -
+// Read a list of keys in format, one of json or yaml.
+func ParseAPIKeys(r io.Reader, format string) (*APIKeys, error) {
+	var keys []APIKey
+	var err error
+	switch format {
+	case "json":
+		err = json.NewDecoder(r).Decode(&keys)
+	case "yaml", "yml":
+		err = yaml.NewDecoder(r).Decode(&keys)
+	default:
+		return nil, fmt.Errorf("unsupported api key format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s api keys error %w", format, err)
+	}
+	return NewAPIKeys(keys)
+}
+
+// LoadAPIKeys -
+// Read the keys in the file at path, the format is taken from its extension.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loadAPIKeys: reading %s error %w", path, err)
+	}
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	a, err := ParseAPIKeys(bytes.NewReader(b), format)
+	if err != nil {
+		return nil, fmt.Errorf("loadAPIKeys: %s %w", path, err)
+	}
+	return a, nil
+}
+
+// RequireAPIKey -
+// Only pass requests to next that carry one of keys, either as
+// `Authorization: Bearer <key>` or in an X-API-Key header, and are within the
+// key's limits. Responses say what is left with X-RateLimit-Limit,
+// X-RateLimit-Remaining, and X-RateLimit-Reset (seconds until the limit is
+// back to full) for the per minute limit, and the same X-RateLimit-Daily-
+// headers for the daily limit. A missing or unknown key is a 401, and a key
+// over its limits a 429 with a Retry-After header.
+func RequireAPIKey(keys *APIKeys, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := requestAPIKey(r)
+		c, ok := keys.byHash[HashAPIKey(key)]
+		if key == "" || !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="weather"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "a valid API key is required"})
+			return
+		}
+		if err := c.take(w.Header()); err != nil {
+			w.Header().Set("Retry-After", headerSeconds(err.wait))
+			writeJSON(w, http.StatusTooManyRequests, errorResponse{Error: err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAPIKey -
+// The key r was sent with, if any.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return auth[len("Bearer "):]
+	}
+	return ""
+}
+
+// take -
+// Count a request, or say why it cannot be made, setting the X-RateLimit
+// headers either way.
+func (c *client) take(h http.Header) *limitError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := timeNow()
+	if day := quotaDay(now); day != c.day {
+		c.day, c.used = day, 0
+	}
+	var err *limitError
+	// the day's limit first, it has the longer wait
+	switch {
+	case c.Daily > 0 && c.used >= c.Daily:
+		err = &limitError{kind: ErrQuotaExceeded, msg: fmt.Sprintf("%s allows %d requests a day", c.Name, c.Daily), wait: nextDay(now).Sub(now)}
+	case c.bucket != nil && c.bucket.wait(now) > 0:
+		err = &limitError{kind: ErrRateLimited, msg: fmt.Sprintf("%s allows %d requests a minute", c.Name, c.PerMinute), wait: c.bucket.wait(now)}
+	default:
+		if c.bucket != nil {
+			c.bucket.take()
+		}
+		c.used++
+	}
+	if c.bucket != nil {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(c.PerMinute))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(c.bucket.available(now)))
+		h.Set("X-RateLimit-Reset", headerSeconds(c.bucket.full(now)))
+	}
+	if c.Daily > 0 {
+		remaining := c.Daily - c.used
+		if remaining < 0 {
+			remaining = 0
+		}
+		h.Set("X-RateLimit-Daily-Limit", strconv.Itoa(c.Daily))
+		h.Set("X-RateLimit-Daily-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Daily-Reset", headerSeconds(nextDay(now).Sub(now)))
+	}
+	return err
+}
+
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// nextDay -
+// When the UTC day containing t ends.
+func nextDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,176 @@
+package weather
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the hash of "secret"
+const secretHash = "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+
+func TestHashAPIKey(t *testing.T) {
+	assert.Equal(t, secretHash, HashAPIKey("secret"))
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	testcases := map[string]struct {
+		format string
+		input  string
+		err    string
+	}{
+		"json": {
+			format: "json",
+			input:  `[{"name":"alice","sha256":"` + secretHash + `","per_minute":60,"daily":1000}]`,
+		},
+		"yaml": {
+			format: "yaml",
+			input: `
+- name: alice
+  sha256: ` + strings.ToUpper(secretHash) + `
+  per_minute: 60
+  daily: 1000
+`,
+		},
+		"unsupported format": {
+			format: "csv",
+			err:    `unsupported api key format "csv"`,
+		},
+		"bad json": {
+			format: "json",
+			input:  `[`,
+			err:    "parsing json api keys error unexpected EOF",
+		},
+		"no name": {
+			format: "json",
+			input:  `[{"sha256":"` + secretHash + `"}]`,
+			err:    "api key 0 has no name",
+		},
+		"key instead of hash": {
+			format: "json",
+			input:  `[{"name":"alice","sha256":"secret"}]`,
+			err:    "api key alice sha256 must be 64 hex digits",
+		},
+		"negative limit": {
+			format: "json",
+			input:  `[{"name":"alice","sha256":"` + secretHash + `","daily":-1}]`,
+			err:    "api key alice limits cannot be negative",
+		},
+		"same key twice": {
+			format: "json",
+			input:  `[{"name":"alice","sha256":"` + secretHash + `"},{"name":"bob","sha256":"` + secretHash + `"}]`,
+			err:    "api keys alice and bob are the same",
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			keys, err := ParseAPIKeys(strings.NewReader(tc.input), tc.format)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			c := keys.byHash[secretHash]
+			assert.NotNil(t, c)
+			assert.Equal(t, APIKey{Name: "alice", SHA256: secretHash, PerMinute: 60, Daily: 1000}, c.APIKey)
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("- name: alice\n  sha256: "+secretHash+"\n"), 0o600))
+	keys, err := LoadAPIKeys(path)
+	assert.Nil(t, err)
+	assert.Contains(t, keys.byHash, secretHash)
+
+	_, err = LoadAPIKeys(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	start := time.Date(2021, 11, 11, 23, 59, 0, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	keys, err := NewAPIKeys([]APIKey{
+		{Name: "alice", SHA256: secretHash, PerMinute: 2, Daily: 3},
+		{Name: "bob", SHA256: HashAPIKey("open")},
+	})
+	assert.Nil(t, err)
+	handler := RequireAPIKey(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	call := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/weather?city=melbourne", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// no key, or the wrong one
+	for _, rr := range []*httptest.ResponseRecorder{
+		call("", ""),
+		call("X-API-Key", "guess"),
+		call("Authorization", "Basic c2VjcmV0"),
+		call("Authorization", "Bearer "+secretHash),
+	} {
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"error":"a valid API key is required"}`, rr.Body.String())
+		assert.Equal(t, `Bearer realm="weather"`, rr.Header().Get("WWW-Authenticate"))
+	}
+
+	// either header will do
+	rr := call("Authorization", "Bearer secret")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, "3", rr.Header().Get("X-RateLimit-Daily-Limit"))
+	assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Daily-Remaining"))
+	assert.Equal(t, "60", rr.Header().Get("X-RateLimit-Daily-Reset"))
+	rr = call("X-API-Key", "secret")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "60", rr.Header().Get("X-RateLimit-Reset"))
+
+	// over the per minute limit
+	rr = call("X-API-Key", "secret")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.JSONEq(t, `{"error":"rate limited, alice allows 2 requests a minute"}`, rr.Body.String())
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Daily-Remaining"))
+
+	// over the daily limit
+	now = start.Add(30 * time.Second)
+	assert.Equal(t, http.StatusNoContent, call("X-API-Key", "secret").Code)
+	now = start.Add(59 * time.Second)
+	rr = call("X-API-Key", "secret")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.JSONEq(t, `{"error":"quota exceeded, alice allows 3 requests a day"}`, rr.Body.String())
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Daily-Remaining"))
+
+	// a new day
+	now = start.Add(time.Minute)
+	rr = call("X-API-Key", "secret")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Daily-Remaining"))
+
+	// a key without limits
+	rr = call("X-API-Key", "open")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Header().Get("X-RateLimit-Limit"))
+	assert.Empty(t, rr.Header().Get("X-RateLimit-Daily-Limit"))
+}
@@ -0,0 +1,395 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Quota -
+// The calls made to each provider this month, kept in a file so that the
+// count survives restarts. Months are UTC calendar months, which may not line
+// up with a provider's billing period.
+type Quota struct {
+	mu    sync.Mutex
+	path  string
+	month string
+	used  map[string]int
+}
+
+// quotaFile -
+// The saved form of a Quota.
+type quotaFile struct {
+	Month string         `json:"month"`
+	Used  map[string]int `json:"used"`
+}
+
+// LoadQuota -
+// The counts saved at path, or a fresh count when there is no file yet. An
+// empty path keeps the counts in memory only.
+func LoadQuota(path string) (*Quota, error) {
+	q := &Quota{path: path, month: quotaMonth(timeNow()), used: map[string]int{}}
+	if path == "" {
+		return q, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	saved := quotaFile{}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// a count from an earlier month no longer applies
+	if saved.Month == q.month && saved.Used != nil {
+		q.used = saved.Used
+	}
+	return q, nil
+}
+
+// Used -
+// The calls made to provider this month.
+func (q *Quota) Used(provider string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	return q.used[provider]
+}
+
+// take -
+// Count a call to provider, unless limit calls have already been made this
+// month.
+func (q *Quota) take(provider string, limit int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	if q.used[provider] >= limit {
+		return false
+	}
+	q.used[provider]++
+	// the call is made either way, it is better to undercount on disk than
+	// to stop serving
+	if err := q.save(); err != nil {
+		log.Printf("ERROR saving quota to %s: %v", q.path, err)
+	}
+	return true
+}
+
+// rollover -
+// Start counting again in a new month. The caller holds mu.
+func (q *Quota) rollover() {
+	if month := quotaMonth(timeNow()); month != q.month {
+		q.month, q.used = month, map[string]int{}
+	}
+}
+
+// save -
+// Write the counts to a temporary file and move it into place, so that a
+// crash cannot leave a partly written file. The caller holds mu.
+func (q *Quota) save() error {
+	if q.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(quotaFile{Month: q.month, Used: q.used})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path)
+}
+
+func quotaMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// nextMonth -
+// When the month containing t ends.
+func nextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// LimitPolicy -
+// How often a provider may be called. Zero means no limit.
+type LimitPolicy struct {
+	// PerMinute is the average number of calls allowed each minute
+	PerMinute int
+	// Burst is the most calls that can be made at once, after a quiet spell,
+	// it defaults to PerMinute
+	Burst int
+	// Monthly is the number of calls allowed each month
+	Monthly int
+}
+
+// Limiter -
+// Limits the calls made to a provider, see WithLimit.
+type Limiter struct {
+	p      Provider
+	policy LimitPolicy
+	quota  *Quota
+
+	mu sync.Mutex
+	// nil without a per minute limit
+	bucket *bucket
+}
+
+// WithLimit -
+// Call p no more often than policy allows, counting monthly calls in quota.
+// Calls over the limit are not made, they fail straight away with
+// ErrRateLimited, or ErrQuotaExceeded once the month's calls are used up,
+// saying how long until the provider can be called again.
+func WithLimit(p Provider, policy LimitPolicy, quota *Quota) (*Limiter, error) {
+	if policy.PerMinute < 0 || policy.Burst < 0 || policy.Monthly < 0 {
+		return nil, fmt.Errorf("limits cannot be negative")
+	}
+	if policy.Monthly > 0 && quota == nil {
+		return nil, fmt.Errorf("a quota is required for a monthly limit")
+	}
+	if policy.Burst == 0 {
+		policy.Burst = policy.PerMinute
+	}
+	l := &Limiter{p: p, policy: policy, quota: quota}
+	if policy.PerMinute > 0 {
+		l.bucket = newBucket(policy.PerMinute, policy.Burst, timeNow())
+	}
+	return l, nil
+}
+
+// Name -
+func (l *Limiter) Name() string {
+	return providerName(l.p)
+}
+
+// GetWeatherContext -
+func (l *Limiter) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	if err := l.take(); err != nil {
+		return Observation{}, err
+	}
+	return l.p.GetWeatherContext(ctx, loc)
+}
+
+// GetForecastContext -
+// Providers that cannot forecast report ErrNotSupported.
+func (l *Limiter) GetForecastContext(ctx context.Context, loc Location, hours int) (Forecast, error) {
+	f, ok := l.p.(Forecaster)
+	if !ok {
+		return Forecast{}, ErrNotSupported
+	}
+	if err := l.take(); err != nil {
+		return Forecast{}, err
+	}
+	return f.GetForecastContext(ctx, loc, hours)
+}
+
+// GetHistoryContext -
+// Providers without history report ErrNotSupported.
+func (l *Limiter) GetHistoryContext(ctx context.Context, loc Location, date time.Time) (History, error) {
+	h, ok := l.p.(HistoryProvider)
+	if !ok {
+		return History{}, ErrNotSupported
+	}
+	if err := l.take(); err != nil {
+		return History{}, err
+	}
+	return h.GetHistoryContext(ctx, loc, date)
+}
+
+// take -
+// Use up a call, or say why it cannot be made.
+func (l *Limiter) take() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := timeNow()
+	if l.bucket != nil {
+		if wait := l.bucket.wait(now); wait > 0 {
+			return &limitError{kind: ErrRateLimited, msg: fmt.Sprintf("%s allows %d calls a minute", l.Name(), l.policy.PerMinute), wait: wait}
+		}
+	}
+	if l.policy.Monthly > 0 && !l.quota.take(l.Name(), l.policy.Monthly) {
+		return &limitError{kind: ErrQuotaExceeded, msg: fmt.Sprintf("%s allows %d calls a month", l.Name(), l.policy.Monthly), wait: nextMonth(now).Sub(now)}
+	}
+	if l.bucket != nil {
+		l.bucket.take()
+	}
+	return nil
+}
+
+// bucket -
+// A token bucket earning perMinute tokens a minute, holding at most burst. It
+// is not safe for concurrent use.
+type bucket struct {
+	perMinute int
+	burst     int
+	tokens    float64
+	filled    time.Time
+}
+
+// newBucket -
+// A full bucket.
+func newBucket(perMinute, burst int, now time.Time) *bucket {
+	return &bucket{perMinute: perMinute, burst: burst, tokens: float64(burst), filled: now}
+}
+
+// fill -
+// Add the tokens earned since the bucket was last filled.
+func (b *bucket) fill(now time.Time) {
+	b.tokens += now.Sub(b.filled).Seconds() * b.rate()
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.filled = now
+}
+
+// wait -
+// How long until a token can be taken, zero when one is there now.
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.fill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate() * float64(time.Second))
+}
+
+// take -
+// Use up a token, after wait has said there is one.
+func (b *bucket) take() {
+	b.tokens--
+}
+
+// available -
+// The tokens that can be taken now.
+func (b *bucket) available(now time.Time) int {
+	b.fill(now)
+	return int(b.tokens)
+}
+
+// full -
+// How long until the bucket is full again.
+func (b *bucket) full(now time.Time) time.Duration {
+	b.fill(now)
+	return time.Duration((float64(b.burst) - b.tokens) / b.rate() * float64(time.Second))
+}
+
+// rate -
+// Tokens earned each second.
+func (b *bucket) rate() float64 {
+	return float64(b.perMinute) / 60
+}
+
+// LimitStatus -
+// The calls a provider has left, for the admin endpoint and metrics. The
+// counts of limits that are not set are left out.
+type LimitStatus struct {
+	Provider string `json:"provider"`
+	// Available is the number of calls that can be made straight away
+	Available *int `json:"available,omitempty"`
+	PerMinute int  `json:"per_minute,omitempty"`
+	// Used and Remaining count this month's calls
+	Used      *int       `json:"used,omitempty"`
+	Remaining *int       `json:"remaining,omitempty"`
+	Monthly   int        `json:"monthly,omitempty"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"`
+}
+
+// Status -
+func (l *Limiter) Status() LimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := timeNow()
+	s := LimitStatus{Provider: l.Name(), PerMinute: l.policy.PerMinute, Monthly: l.policy.Monthly}
+	if l.bucket != nil {
+		available := l.bucket.available(now)
+		s.Available = &available
+	}
+	if l.policy.Monthly > 0 {
+		used := l.quota.Used(l.Name())
+		remaining := l.policy.Monthly - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		resets := nextMonth(now)
+		s.Used, s.Remaining, s.ResetsAt = &used, &remaining, &resets
+	}
+	return s
+}
+
+// limitError -
+// A call the limiter did not make.
+type limitError struct {
+	kind error
+	msg  string
+	wait time.Duration
+}
+
+// Error -
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%v, %s", e.kind, e.msg)
+}
+
+// Unwrap -
+func (e *limitError) Unwrap() error {
+	return e.kind
+}
+
+// RetryAfter -
+func (e *limitError) RetryAfter() (time.Duration, bool) {
+	return e.wait, true
+}
+
+// LimitAdmin -
+// An endpoint listing the calls each provider has left, eg.
+//
+//	{"limits":[{"provider":"weatherstack","used":200,"remaining":50,"monthly":250,...}]}
+func LimitAdmin(limiters ...*Limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]LimitStatus{"limits": limitStatuses(limiters)})
+	})
+}
+
+// LimitMetrics -
+// The status of each limiter, keyed by provider, for publishing with
+// expvar.Publish.
+func LimitMetrics(limiters ...*Limiter) expvar.Var {
+	return expvar.Func(func() interface{} {
+		metrics := map[string]LimitStatus{}
+		for _, s := range limitStatuses(limiters) {
+			metrics[s.Provider] = s
+		}
+		return metrics
+	})
+}
+
+func limitStatuses(limiters []*Limiter) []LimitStatus {
+	statuses := make([]LimitStatus, len(limiters))
+	for i := range limiters {
+		statuses[i] = limiters[i].Status()
+	}
+	return statuses
+}
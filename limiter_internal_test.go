@@ -0,0 +1,202 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterPerMinute(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	start := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	p := &scriptedProvider{errs: make([]error, 10)}
+	l, err := WithLimit(p, LimitPolicy{PerMinute: 60, Burst: 2}, nil)
+	assert.Nil(t, err)
+	call := func() error {
+		_, err := l.GetWeatherContext(context.Background(), Location{})
+		return err
+	}
+
+	// the burst
+	assert.Nil(t, call())
+	assert.Nil(t, call())
+
+	// skipped, the provider is not called
+	err = call()
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.EqualError(t, err, "rate limited, *weather.scriptedProvider allows 60 calls a minute")
+	wait, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, wait)
+	assert.Equal(t, 2, p.calls)
+
+	// one call a second is earned
+	now = start.Add(500 * time.Millisecond)
+	wait, _ = RetryAfter(call())
+	assert.Equal(t, 500*time.Millisecond, wait)
+	now = start.Add(time.Second)
+	assert.Nil(t, call())
+	assert.True(t, errors.Is(call(), ErrRateLimited))
+
+	// no more than the burst is saved up
+	now = start.Add(time.Hour)
+	assert.Equal(t, 2, *l.Status().Available)
+	assert.Equal(t, 3, p.calls)
+}
+
+func TestLimiterMonthly(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2021, 11, 30, 23, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	path := filepath.Join(t.TempDir(), "quota.json")
+	quota, err := LoadQuota(path)
+	assert.Nil(t, err)
+	p := &scriptedProvider{errs: make([]error, 10)}
+	l, err := WithLimit(p, LimitPolicy{Monthly: 2}, quota)
+	assert.Nil(t, err)
+	call := func() error {
+		_, err := l.GetWeatherContext(context.Background(), Location{})
+		return err
+	}
+
+	assert.Nil(t, call())
+	assert.Nil(t, call())
+	err = call()
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	assert.EqualError(t, err, "quota exceeded, *weather.scriptedProvider allows 2 calls a month")
+	// until the start of next month
+	wait, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, wait)
+	assert.Equal(t, 2, p.calls)
+
+	resets := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	used, remaining := 2, 0
+	assert.Equal(t, LimitStatus{Provider: "*weather.scriptedProvider", Used: &used, Remaining: &remaining, Monthly: 2, ResetsAt: &resets}, l.Status())
+
+	// the count survives a restart
+	saved, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"month":"2021-11","used":{"*weather.scriptedProvider":2}}`, string(saved))
+	quota, err = LoadQuota(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, quota.Used("*weather.scriptedProvider"))
+
+	// and starts again next month
+	now = resets
+	assert.Nil(t, call())
+	assert.Equal(t, 3, p.calls)
+	quota, err = LoadQuota(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, quota.Used("*weather.scriptedProvider"))
+}
+
+func TestLimiterBothLimits(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	quota, err := LoadQuota("")
+	assert.Nil(t, err)
+	l, err := WithLimit(&scriptedProvider{errs: make([]error, 10)}, LimitPolicy{PerMinute: 1, Monthly: 10}, quota)
+	assert.Nil(t, err)
+
+	_, err = l.GetWeatherContext(context.Background(), Location{})
+	assert.Nil(t, err)
+	// calls skipped for the rate do not count against the month
+	_, err = l.GetWeatherContext(context.Background(), Location{})
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, 1, quota.Used("*weather.scriptedProvider"))
+}
+
+func TestLoadQuota(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC) }
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0o600))
+		return path
+	}
+
+	q, err := LoadQuota(filepath.Join(dir, "missing.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, q.Used("weatherstack"))
+
+	q, err = LoadQuota(write("current.json", `{"month":"2021-11","used":{"weatherstack":7}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 7, q.Used("weatherstack"))
+
+	q, err = LoadQuota(write("old.json", `{"month":"2021-10","used":{"weatherstack":7}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, q.Used("weatherstack"))
+
+	_, err = LoadQuota(write("bad.json", `[`))
+	assert.EqualError(t, err, filepath.Join(dir, "bad.json")+": unexpected end of JSON input")
+}
+
+func TestLimiterForwarding(t *testing.T) {
+	l, err := WithLimit(&scriptedProvider{}, LimitPolicy{}, nil)
+	assert.Nil(t, err)
+	_, err = l.GetForecastContext(context.Background(), Location{}, 24)
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = l.GetHistoryContext(context.Background(), Location{}, time.Now())
+	assert.Equal(t, ErrNotSupported, err)
+
+	// forecasts use the same budget
+	f := &fakeForecaster{}
+	l, err = WithLimit(f, LimitPolicy{PerMinute: 1}, nil)
+	assert.Nil(t, err)
+	_, err = l.GetForecastContext(context.Background(), Location{}, 24)
+	assert.Nil(t, err)
+	_, err = l.GetWeatherContext(context.Background(), Location{})
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, 1, f.calls)
+	assert.Equal(t, "fake", l.Name())
+}
+
+func TestWithLimitValidation(t *testing.T) {
+	_, err := WithLimit(&scriptedProvider{}, LimitPolicy{PerMinute: -1}, nil)
+	assert.EqualError(t, err, "limits cannot be negative")
+	_, err = WithLimit(&scriptedProvider{}, LimitPolicy{Monthly: 100}, nil)
+	assert.EqualError(t, err, "a quota is required for a monthly limit")
+}
+
+func TestLimitAdmin(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC) }
+
+	quota, err := LoadQuota("")
+	assert.Nil(t, err)
+	// its own provider, the fake one shares its answer with other tests
+	l, err := WithLimit(&scriptedProvider{errs: []error{nil}}, LimitPolicy{PerMinute: 60, Monthly: 250}, quota)
+	assert.Nil(t, err)
+	_, err = l.GetWeatherContext(context.Background(), Location{})
+	assert.Nil(t, err)
+	unlimited, err := WithLimit(&fakeProvider{}, LimitPolicy{}, nil)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	LimitAdmin(l, unlimited).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/limits", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"limits":[
+		{"provider":"*weather.scriptedProvider","available":59,"per_minute":60,"used":1,"remaining":249,"monthly":250,"resets_at":"2021-12-01T00:00:00Z"},
+		{"provider":"fake"}]}`, rr.Body.String())
+	assert.JSONEq(t, `{"*weather.scriptedProvider":{"provider":"*weather.scriptedProvider","available":59,"per_minute":60,"used":1,"remaining":249,"monthly":250,"resets_at":"2021-12-01T00:00:00Z"},
+		"fake":{"provider":"fake"}}`, LimitMetrics(l, unlimited).String())
+
+	rr = httptest.NewRecorder()
+	LimitAdmin(l).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/limits", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
@@ -0,0 +1,122 @@
+package weather
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// LocationID -
+// Canonical identity of a location. Every spelling of a location that a client
+// might send, eg. "Melbourne", "melbourne", or " MELBOURNE ", maps to the same
+// LocationID, which is then used for validation, caching, rate limiting, and
+// provider lookup.
+type LocationID string
+
+// NewLocationID -
+// Canonicalise a location name supplied by a client.
+// The name is first put in Unicode normal form C, so that eg. "ü" is the same
+// whether it was sent as one code point or as "u" and a combining diaeresis.
+// Letters are lower cased, including non ASCII letters, invisible formatting
+// characters such as zero width spaces are dropped, and any run of white space,
+// including non breaking spaces, becomes a single space with none at either
+// end.
+func NewLocationID(name string) LocationID {
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.Is(unicode.Cf, r), unicode.IsControl(r):
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, norm.NFC.String(name))
+	return LocationID(strings.Join(strings.Fields(cleaned), " "))
+}
+
+// String -
+func (l LocationID) String() string {
+	return string(l)
+}
+
+// Location -
+// A place the service can report the weather for.
+type Location struct {
+	ID LocationID `json:"id" yaml:"id"`
+	// Name is how the location is displayed
+	Name string `json:"name" yaml:"name"`
+	// Country is the ISO 3166-1 alpha-2 code, eg. AU
+	Country  string  `json:"country" yaml:"country"`
+	Region   string  `json:"region,omitempty" yaml:"region,omitempty"`
+	Lat      float64 `json:"lat" yaml:"lat"`
+	Lon      float64 `json:"lon" yaml:"lon"`
+	Timezone string  `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	// Postcode is only set for locations looked up by postcode
+	Postcode string `json:"postcode,omitempty" yaml:"postcode,omitempty"`
+	// Aliases maps a provider name to the query that provider understands for
+	// this location, providers build their own query when there is no alias
+	Aliases map[string]string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+}
+
+// HasCoordinates -
+// Whether the latitude and longitude are known. A location looked up by
+// latitude and longitude always has them, even 0,0. Otherwise 0,0 is in the
+// ocean, so it is taken to mean they are not.
+func (l Location) HasCoordinates() bool {
+	return strings.HasPrefix(l.ID.String(), coordinatePrefix) || l.Lat != 0 || l.Lon != 0
+}
+
+// Alias -
+// The query the named provider uses for this location, if one has been set.
+func (l Location) Alias(provider string) (string, bool) {
+	a, ok := l.Aliases[provider]
+	return a, ok && a != ""
+}
+
+// Precision coordinates are rounded to in a coordinate LocationID, about 11m
+// at the equator, so nearby lookups share a cache entry
+const coordinatePrecision = 4
+
+// coordinatePrefix starts the LocationID of a coordinate location
+const coordinatePrefix = "coord:"
+
+// NewCoordinateLocation -
+// A location that is not in the registry, looked up by latitude and longitude.
+func NewCoordinateLocation(lat, lon float64) (Location, error) {
+	// written so that NaN, which fails every comparison, is rejected too
+	if !(lat >= -90 && lat <= 90) {
+		return Location{}, fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if !(lon >= -180 && lon <= 180) {
+		return Location{}, fmt.Errorf("longitude must be between -180 and 180")
+	}
+	name := fmt.Sprintf("%.*f,%.*f", coordinatePrecision, lat, coordinatePrecision, lon)
+	return Location{
+		ID:   LocationID(coordinatePrefix + name),
+		Name: name,
+		Lat:  lat,
+		Lon:  lon,
+	}, nil
+}
+
+// NewPostcodeLocation -
+// A location that is not in the registry, looked up by postcode. Postcodes are
+// reused between countries, so the country is required.
+func NewPostcodeLocation(postcode, country string) (Location, error) {
+	postcode = strings.ToUpper(NewLocationID(postcode).String())
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if postcode == "" {
+		return Location{}, fmt.Errorf("postcode is required")
+	}
+	if country == "" {
+		return Location{}, fmt.Errorf("country is required with a postcode")
+	}
+	return Location{
+		ID:       NewLocationID("postcode:" + postcode + "," + country),
+		Name:     postcode,
+		Country:  country,
+		Postcode: postcode,
+	}, nil
+}
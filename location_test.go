@@ -0,0 +1,104 @@
+package weather_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLocationID(t *testing.T) {
+	testcases := map[string]struct {
+		name     string
+		expected weather.LocationID
+	}{
+		"already canonical":     {name: "melbourne", expected: "melbourne"},
+		"title case":            {name: "Melbourne", expected: "melbourne"},
+		"upper case":            {name: "MELBOURNE", expected: "melbourne"},
+		"surrounding spaces":    {name: "  melbourne ", expected: "melbourne"},
+		"tabs and newlines":     {name: "\tmelbourne\n", expected: "melbourne"},
+		"inner spaces":          {name: "Alice   Springs", expected: "alice springs"},
+		"non breaking space":    {name: "Alice\u00a0Springs", expected: "alice springs"},
+		"ideographic space":     {name: "\u3000melbourne", expected: "melbourne"},
+		"zero width space":      {name: "mel\u200bbourne", expected: "melbourne"},
+		"byte order mark":       {name: "\ufeffmelbourne", expected: "melbourne"},
+		"non ascii upper case":  {name: "ZÜRICH", expected: "zürich"},
+		"greek upper case":      {name: "ΑΘΗΝΑ", expected: "αθηνα"},
+		"composed":              {name: "Z\u00fcrich", expected: "z\u00fcrich"},
+		"decomposed":            {name: "Zu\u0308rich", expected: "z\u00fcrich"},
+		"decomposed upper case": {name: "ZU\u0308RICH", expected: "z\u00fcrich"},
+		"control characters":    {name: "mel\x00bourne\x7f", expected: "melbourne"},
+		"only white space":      {name: " \t ", expected: ""},
+		"empty":                 {name: "", expected: ""},
+		"punctuation preserved": {name: "St. Kilda", expected: "st. kilda"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, weather.NewLocationID(tc.name))
+		})
+	}
+}
+
+func TestNewCoordinateLocation(t *testing.T) {
+	testcases := map[string]struct {
+		lat, lon float64
+		id       weather.LocationID
+		err      string
+	}{
+		"rounded":        {lat: -37.81412345, lon: 144.96332, id: "coord:-37.8141,144.9633"},
+		"limits":         {lat: 90, lon: -180, id: "coord:90.0000,-180.0000"},
+		"bad latitude":   {lat: -90.1, err: "latitude must be between -90 and 90"},
+		"bad longitude":  {lon: 180.1, err: "longitude must be between -180 and 180"},
+		"null island id": {id: "coord:0.0000,0.0000"},
+		"nan latitude":   {lat: math.NaN(), err: "latitude must be between -90 and 90"},
+		"nan longitude":  {lon: math.NaN(), err: "longitude must be between -180 and 180"},
+		"inf latitude":   {lat: math.Inf(1), err: "latitude must be between -90 and 90"},
+		"inf longitude":  {lon: math.Inf(-1), err: "longitude must be between -180 and 180"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			l, err := weather.NewCoordinateLocation(tc.lat, tc.lon)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.id, l.ID)
+			assert.Equal(t, tc.lat, l.Lat)
+			assert.Equal(t, tc.lon, l.Lon)
+			// even 0,0 is used as coordinates
+			assert.True(t, l.HasCoordinates())
+		})
+	}
+}
+
+func TestNewPostcodeLocation(t *testing.T) {
+	testcases := map[string]struct {
+		postcode, country string
+		expected          weather.Location
+		err               string
+	}{
+		"australian": {
+			postcode: "3000", country: "au",
+			expected: weather.Location{ID: "postcode:3000,au", Name: "3000", Postcode: "3000", Country: "AU"},
+		},
+		"spaces and case": {
+			postcode: " sw1a  1aa ", country: "GB",
+			expected: weather.Location{ID: "postcode:sw1a 1aa,gb", Name: "SW1A 1AA", Postcode: "SW1A 1AA", Country: "GB"},
+		},
+		"no postcode": {country: "AU", err: "postcode is required"},
+		"no country":  {postcode: "3000", err: "country is required with a postcode"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			l, err := weather.NewPostcodeLocation(tc.postcode, tc.country)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, l)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package weather
+
+import (
+	"time"
+
+	"github.com/shanehowearth/weather/units"
+)
+
+// Unit names used in Observation.Units, more are in the units package
+const (
+	Celsius           = units.Celsius
+	MetresPerSecond   = units.MetresPerSecond
+	KilometresPerHour = units.KilometresPerHour
+)
+
+// Observation -
+// The weather at a location, as reported by a single provider.
+// Providers outside of this repository can build one directly, any field they
+// cannot fill is left as the zero value, and the optional measurements as nil.
+type Observation struct {
+	Temperature float64   `json:"temperature_degrees"`
+	WindSpeed   float64   `json:"wind_speed"`
+	Units       Units     `json:"units"`
+	ObservedAt  time.Time `json:"observed_at"`
+	Provider    string    `json:"provider"`
+	Location    string    `json:"location"`
+
+	// FeelsLike is the apparent temperature, in Units.Temperature
+	FeelsLike *float64 `json:"feels_like,omitempty"`
+	// WindGust is in Units.WindSpeed
+	WindGust *float64 `json:"wind_gust,omitempty"`
+	// WindDirection is the direction the wind blows from, in degrees
+	// clockwise from north
+	WindDirection *float64 `json:"wind_direction,omitempty"`
+	// Humidity is the relative humidity, in percent
+	Humidity *float64 `json:"humidity,omitempty"`
+	// Pressure is the sea level pressure, in hectopascals
+	Pressure *float64 `json:"pressure,omitempty"`
+	// Visibility is in kilometres
+	Visibility *float64 `json:"visibility,omitempty"`
+	// CloudCover is in percent
+	CloudCover *float64 `json:"cloud_cover,omitempty"`
+	// Precipitation is the rain and snow over the last hour, in millimetres
+	Precipitation *float64   `json:"precipitation,omitempty"`
+	Condition     *Condition `json:"condition,omitempty"`
+
+	// Stale is set when the observation is a previously cached value served
+	// because no provider could supply a fresh one
+	Stale bool `json:"stale,omitempty"`
+	// Consensus is set when the observation combines several providers
+	Consensus *ConsensusReport `json:"consensus,omitempty"`
+}
+
+// Units -
+// The units that the values in an Observation are measured in.
+type Units struct {
+	Temperature units.Temperature `json:"temperature"`
+	WindSpeed   units.Speed       `json:"wind_speed"`
+}
+
+// In -
+// o with its temperatures and speeds converted to sys. Values without units,
+// eg. from a provider that does not set them, are left as they are.
+func (o Observation) In(sys units.System) (Observation, error) {
+	c := converter{from: o.Units, to: sys}
+	// the values pointed to may be shared with a cached observation
+	o.FeelsLike, o.WindGust = clone(o.FeelsLike), clone(o.WindGust)
+	c.temperature(&o.Temperature)
+	c.temperature(o.FeelsLike)
+	c.speed(&o.WindSpeed)
+	c.speed(o.WindGust)
+	if o.Consensus != nil {
+		// the report is shared with a cached observation too
+		report := *o.Consensus
+		c.temperatureDifference(&report.TemperatureSpread)
+		c.speed(&report.WindSpeedSpread)
+		o.Consensus = &report
+	}
+	if c.err != nil {
+		return Observation{}, c.err
+	}
+	o.Units = c.units()
+	return o, nil
+}
+
+func clone(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	return &c
+}
+
+// converter -
+// Converts values in place from one set of units to another, keeping the
+// first error.
+type converter struct {
+	from Units
+	to   units.System
+	err  error
+}
+
+func (c *converter) temperature(v *float64) {
+	if v == nil || c.from.Temperature == "" || c.err != nil {
+		return
+	}
+	*v, c.err = units.ConvertTemperature(*v, c.from.Temperature, c.to.Temperature)
+}
+
+// temperatureDifference -
+// Convert the difference between two temperatures, which is only scaled, eg.
+// a spread of 1°C is 1.8°F, not 33.8°F.
+func (c *converter) temperatureDifference(v *float64) {
+	if v == nil || c.from.Temperature == "" || c.err != nil {
+		return
+	}
+	var zero, t float64
+	if zero, c.err = units.ConvertTemperature(0, c.from.Temperature, c.to.Temperature); c.err != nil {
+		return
+	}
+	if t, c.err = units.ConvertTemperature(*v, c.from.Temperature, c.to.Temperature); c.err != nil {
+		return
+	}
+	*v = t - zero
+}
+
+func (c *converter) speed(v *float64) {
+	if v == nil || c.from.WindSpeed == "" || c.err != nil {
+		return
+	}
+	*v, c.err = units.ConvertSpeed(*v, c.from.WindSpeed, c.to.Speed)
+}
+
+// units -
+// The units the values are in once converted.
+func (c *converter) units() Units {
+	u := c.from
+	if u.Temperature != "" {
+		u.Temperature = c.to.Temperature
+	}
+	if u.WindSpeed != "" {
+		u.WindSpeed = c.to.Speed
+	}
+	return u
+}
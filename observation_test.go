@@ -0,0 +1,66 @@
+package weather_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/weather"
+	"github.com/shanehowearth/weather/units"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservationIn(t *testing.T) {
+	feelsLike, gust, humidity := 10.0, 36.0, 58.0
+	o := weather.Observation{
+		Temperature: 20,
+		WindSpeed:   18,
+		Units:       weather.Units{Temperature: weather.Celsius, WindSpeed: weather.KilometresPerHour},
+		ObservedAt:  time.Unix(1636614234, 0).UTC(),
+		FeelsLike:   &feelsLike,
+		WindGust:    &gust,
+		Humidity:    &humidity,
+	}
+
+	imperial, err := o.In(units.Imperial)
+	assert.Nil(t, err)
+	assert.Equal(t, weather.Units{Temperature: units.Fahrenheit, WindSpeed: units.MilesPerHour}, imperial.Units)
+	assert.InDelta(t, 68, imperial.Temperature, 1e-9)
+	assert.InDelta(t, 50, *imperial.FeelsLike, 1e-9)
+	assert.InDelta(t, 11.18468, imperial.WindSpeed, 1e-5)
+	assert.InDelta(t, 22.36936, *imperial.WindGust, 1e-5)
+	assert.Equal(t, humidity, *imperial.Humidity)
+	assert.Equal(t, o.ObservedAt, imperial.ObservedAt)
+
+	// the original is untouched
+	assert.Equal(t, 10.0, feelsLike)
+	assert.Equal(t, 36.0, gust)
+
+	si, err := o.In(units.SI)
+	assert.Nil(t, err)
+	assert.InDelta(t, 293.15, si.Temperature, 1e-9)
+	assert.InDelta(t, 5, si.WindSpeed, 1e-9)
+
+	// values without units are passed through
+	bare, err := weather.Observation{Temperature: 20, WindSpeed: 18}.In(units.Imperial)
+	assert.Nil(t, err)
+	assert.Equal(t, weather.Observation{Temperature: 20, WindSpeed: 18}, bare)
+
+	// spreads are differences, so are only scaled
+	report := &weather.ConsensusReport{Method: weather.Median, Contributors: []string{"a", "b"}, TemperatureSpread: 5, WindSpeedSpread: 36}
+	o.Consensus = report
+	imperial, err = o.In(units.Imperial)
+	assert.Nil(t, err)
+	assert.InDelta(t, 9, imperial.Consensus.TemperatureSpread, 1e-9)
+	assert.InDelta(t, 22.36936, imperial.Consensus.WindSpeedSpread, 1e-5)
+	assert.Equal(t, []string{"a", "b"}, imperial.Consensus.Contributors)
+	si, err = o.In(units.SI)
+	assert.Nil(t, err)
+	assert.InDelta(t, 5, si.Consensus.TemperatureSpread, 1e-9)
+	assert.InDelta(t, 10, si.Consensus.WindSpeedSpread, 1e-9)
+	// the shared report is untouched
+	assert.Equal(t, 5.0, report.TemperatureSpread)
+	assert.Equal(t, 36.0, report.WindSpeedSpread)
+
+	_, err = weather.Observation{Units: weather.Units{WindSpeed: "beaufort"}}.In(units.Metric)
+	assert.EqualError(t, err, `unknown speed units "beaufort"`)
+}
@@ -0,0 +1,139 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Provider -
+// All weather providers should implement this interface to allow them to be
+// used by the application.
+// The context carries the deadline and cancellation of the inbound request and
+// must be passed on to any upstream calls the provider makes.
+// Providers build their upstream query from the Location, preferring the
+// alias set for them in the registry.
+type Provider interface {
+	GetWeatherContext(ctx context.Context, loc Location) (Observation, error)
+}
+
+// Namer -
+// Providers that implement Namer are identified by that name in errors and
+// logs, otherwise their type is used.
+type Namer interface {
+	Name() string
+}
+
+func providerName(p interface{}) string {
+	if n, ok := p.(Namer); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// LegacyProvider -
+// Providers written before the context aware Provider interface and the
+// Observation type existed.
+// Wrap them with Adapt to use them with the application.
+type LegacyProvider interface {
+	GetWeather(city string) (struct{ Temperature, WindSpeed float64 }, error)
+}
+
+// Adapt -
+// Allow a LegacyProvider to be used where a Provider is required.
+// The legacy call cannot be cancelled, so when the context is done the result
+// is abandoned and the call is left to finish in the background.
+// Legacy providers are given the location name as the city, and do not report
+// their units, observation time, or location, so the observation time is when
+// the call returned and the location is the name that was asked for.
+// The adapter has the same Name as the provider it wraps.
+func Adapt(p LegacyProvider) Provider {
+	return &legacyAdapter{p: p}
+}
+
+type legacyAdapter struct {
+	p LegacyProvider
+}
+
+// Name -
+func (l *legacyAdapter) Name() string {
+	return providerName(l.p)
+}
+
+// GetWeatherContext -
+func (l *legacyAdapter) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	type result struct {
+		val struct{ Temperature, WindSpeed float64 }
+		err error
+	}
+	// buffered so that an abandoned call does not leak its goroutine
+	done := make(chan result, 1)
+	go func() {
+		val, err := l.p.GetWeather(loc.Name)
+		done <- result{val: val, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return Observation{}, ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			return Observation{}, res.err
+		}
+		return Observation{
+			Temperature: res.val.Temperature,
+			WindSpeed:   res.val.WindSpeed,
+			ObservedAt:  timeNow(),
+			Provider:    l.Name(),
+			Location:    loc.Name,
+		}, nil
+	}
+}
+
+// WithTimeout -
+// Bound every call made to p to at most d, on top of any deadline the caller
+// has already set.
+func WithTimeout(p Provider, d time.Duration) Provider {
+	return &timeoutProvider{p: p, timeout: d}
+}
+
+type timeoutProvider struct {
+	p       Provider
+	timeout time.Duration
+}
+
+// Name -
+func (t *timeoutProvider) Name() string {
+	return providerName(t.p)
+}
+
+// GetWeatherContext -
+func (t *timeoutProvider) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.p.GetWeatherContext(ctx, loc)
+}
+
+// GetForecastContext -
+// Providers that cannot forecast report ErrNotSupported.
+func (t *timeoutProvider) GetForecastContext(ctx context.Context, loc Location, hours int) (Forecast, error) {
+	f, ok := t.p.(Forecaster)
+	if !ok {
+		return Forecast{}, ErrNotSupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return f.GetForecastContext(ctx, loc, hours)
+}
+
+// GetHistoryContext -
+// Providers without history report ErrNotSupported.
+func (t *timeoutProvider) GetHistoryContext(ctx context.Context, loc Location, date time.Time) (History, error) {
+	h, ok := t.p.(HistoryProvider)
+	if !ok {
+		return History{}, ErrNotSupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return h.GetHistoryContext(ctx, loc, date)
+}
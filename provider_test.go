@@ -0,0 +1,77 @@
+package weather_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLegacyProvider struct {
+	delay time.Duration
+	resp  struct{ Temperature, WindSpeed float64 }
+	err   error
+}
+
+func (f *fakeLegacyProvider) GetWeather(city string) (struct{ Temperature, WindSpeed float64 }, error) {
+	time.Sleep(f.delay)
+	return f.resp, f.err
+}
+
+type slowProvider struct{}
+
+func (s *slowProvider) GetWeatherContext(ctx context.Context, loc weather.Location) (weather.Observation, error) {
+	<-ctx.Done()
+	return weather.Observation{}, ctx.Err()
+}
+
+func TestAdapt(t *testing.T) {
+	testcases := map[string]struct {
+		provider *fakeLegacyProvider
+		timeout  time.Duration
+		expected weather.Observation
+		err      error
+	}{
+		"successful": {
+			provider: &fakeLegacyProvider{resp: struct{ Temperature, WindSpeed float64 }{10, 20}},
+			timeout:  time.Second,
+			expected: weather.Observation{Temperature: 10, WindSpeed: 20, Provider: "*weather_test.fakeLegacyProvider", Location: "Melbourne"},
+		},
+		"provider error": {
+			provider: &fakeLegacyProvider{err: fmt.Errorf("fake error")},
+			timeout:  time.Second,
+			err:      fmt.Errorf("fake error"),
+		},
+		"context done first": {
+			provider: &fakeLegacyProvider{delay: time.Second},
+			timeout:  time.Millisecond,
+			err:      context.DeadlineExceeded,
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+
+			output, err := weather.Adapt(tc.provider).GetWeatherContext(ctx, weather.Location{ID: "melbourne", Name: "Melbourne"})
+			if tc.err == nil {
+				assert.Nil(t, err)
+				assert.False(t, output.ObservedAt.IsZero())
+				output.ObservedAt = time.Time{}
+				assert.Equal(t, tc.expected, output)
+			} else {
+				assert.EqualError(t, err, tc.err.Error())
+			}
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := weather.WithTimeout(&slowProvider{}, 10*time.Millisecond).GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
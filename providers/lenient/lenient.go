@@ -0,0 +1,55 @@
+// Package lenient decodes the loosely typed JSON that some providers send,
+// where the same field can arrive as an integer, a decimal, or a string.
+package lenient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Number -
+// A float64 that decodes from a JSON number, eg. 15 or 15.5, or a string
+// holding one, eg. "15.5". null leaves the value unchanged, as it does for a
+// float64.
+type Number float64
+
+// UnmarshalJSON -
+func (n *Number) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		b = []byte(strings.TrimSpace(s))
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	// ParseFloat also accepts "NaN" and "Inf", which are not measurements
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("lenient: %s is not a number", b)
+	}
+	*n = Number(f)
+	return nil
+}
+
+// Float64 -
+// n as a float64.
+func (n Number) Float64() float64 {
+	return float64(n)
+}
+
+// Optional -
+// The value of n, or nil when n is missing.
+func Optional(n *Number) *float64 {
+	if n == nil {
+		return nil
+	}
+	f := float64(*n)
+	return &f
+}
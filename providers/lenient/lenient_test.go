@@ -0,0 +1,60 @@
+package lenient_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shanehowearth/weather/providers/lenient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumber(t *testing.T) {
+	testcases := map[string]struct {
+		input    string
+		expected lenient.Number
+		err      string
+	}{
+		"integer":         {input: `15`, expected: 15},
+		"negative":        {input: `-3`, expected: -3},
+		"decimal":         {input: `15.5`, expected: 15.5},
+		"exponent":        {input: `1.5e1`, expected: 15},
+		"string integer":  {input: `"15"`, expected: 15},
+		"string decimal":  {input: `"-37.817"`, expected: -37.817},
+		"string padded":   {input: `" 15.5 "`, expected: 15.5},
+		"null":            {input: `null`, expected: 7},
+		"empty string":    {input: `""`, err: "lenient:  is not a number"},
+		"word":            {input: `"calm"`, err: "lenient: calm is not a number"},
+		"not a number":    {input: `"NaN"`, err: "lenient: NaN is not a number"},
+		"infinity":        {input: `"Inf"`, err: "lenient: Inf is not a number"},
+		"boolean":         {input: `true`, err: "lenient: true is not a number"},
+		"unfinished text": {input: `"15`, err: "unexpected end of JSON input"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			// null must leave the existing value alone
+			n := lenient.Number(7)
+			err := json.Unmarshal([]byte(tc.input), &n)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, n)
+		})
+	}
+}
+
+func TestOptional(t *testing.T) {
+	var v struct {
+		Present *lenient.Number `json:"present"`
+		Null    *lenient.Number `json:"null"`
+		Missing *lenient.Number `json:"missing"`
+	}
+	err := json.Unmarshal([]byte(`{"present":"2.5","null":null}`), &v)
+	assert.Nil(t, err)
+
+	f := 2.5
+	assert.Equal(t, &f, lenient.Optional(v.Present))
+	assert.Nil(t, lenient.Optional(v.Null))
+	assert.Nil(t, lenient.Optional(v.Missing))
+}
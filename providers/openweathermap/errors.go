@@ -0,0 +1,72 @@
+package openweathermap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shanehowearth/weather"
+)
+
+// APIError -
+// A response from OpenWeatherMap with a status other than 200 OK. The body is
+// usually {"cod":401,"message":"Invalid API key..."}, where cod repeats the
+// status.
+type APIError struct {
+	StatusCode int
+	Message    string
+	// retryAfter is from the Retry-After header, if any
+	retryAfter *time.Duration
+}
+
+// Error -
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("got bad status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("got bad status %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap -
+// The kind of failure, if it is one the weather package knows about.
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return weather.ErrAuth
+	case e.StatusCode == http.StatusTooManyRequests:
+		return weather.ErrRateLimited
+	case e.StatusCode == http.StatusNotFound:
+		// eg. "city not found"
+		return weather.ErrUnknownLocation
+	case e.StatusCode >= http.StatusInternalServerError:
+		return weather.ErrUnavailable
+	}
+	return nil
+}
+
+// RetryAfter -
+// How long OpenWeatherMap asked to be left alone for, if it said.
+func (e *APIError) RetryAfter() (time.Duration, bool) {
+	if e.retryAfter == nil {
+		return 0, false
+	}
+	return *e.retryAfter, true
+}
+
+// newAPIError -
+// The error for resp, body is used when it holds the error envelope and
+// ignored otherwise.
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	var envelope struct {
+		Message string `json:"message"`
+	}
+	e := &APIError{StatusCode: resp.StatusCode}
+	if wait, ok := weather.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		e.retryAfter = &wait
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil {
+		e.Message = envelope.Message
+	}
+	return e
+}
@@ -1,98 +1,462 @@
 package openweathermap
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/shanehowearth/weather"
 )
 
+// Name - identifies this provider in observations and errors
+const Name = "openweathermap"
+
+// The query parameter carrying the app id, redacted from errors
+const appIDParam = "appid"
+
+// The API called unless WithBaseURL is given
+const defaultURL = "https://api.openweathermap.org"
+
 // OpenWeather -
 type OpenWeather struct {
-	url   string
-	appID string
+	url       string
+	appID     string
+	client    *http.Client
+	userAgent string
+}
+
+// Option -
+// Configures the provider created by NewOpenWeather.
+type Option func(*options)
+
+type options struct {
+	client    *http.Client
+	transport http.RoundTripper
+	baseURL   string
+	timeout   time.Duration
+	userAgent string
+}
+
+// WithHTTPClient -
+// Make calls with c, eg. one set up for proxies or TLS. The default is a
+// client like http.DefaultClient. The other options apply to a copy, c itself
+// is not changed.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithTransport -
+// Make calls through rt, eg. an *http.Transport with its own connection
+// pooling.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = rt
+	}
+}
+
+// WithBaseURL -
+// Call the API at u instead of OpenWeatherMap, eg. a local stub server. u is
+// the root the versioned paths, eg. /data/2.5/weather, are added to.
+func WithBaseURL(u string) Option {
+	return func(o *options) {
+		o.baseURL = u
+	}
+}
+
+// WithTimeout -
+// The longest a call may take, including reading the response. The default
+// is no limit beyond the caller's context.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithUserAgent -
+// Send ua as the User-Agent of every call.
+func WithUserAgent(ua string) Option {
+	return func(o *options) {
+		o.userAgent = ua
+	}
 }
 
 // NewOpenWeather -
-func NewOpenWeather(appID string) (*OpenWeather, error) {
+func NewOpenWeather(appID string, opts ...Option) (*OpenWeather, error) {
 	if appID == "" {
 		return nil, fmt.Errorf("appID is required")
 	}
+	o := options{baseURL: defaultURL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if u, err := url.Parse(o.baseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base URL must be absolute, got %q", o.baseURL)
+	}
+	if o.timeout < 0 {
+		return nil, fmt.Errorf("timeout cannot be negative")
+	}
+	client := http.Client{}
+	if o.client != nil {
+		client = *o.client
+	}
+	if o.transport != nil {
+		client.Transport = o.transport
+	}
+	if o.timeout > 0 {
+		client.Timeout = o.timeout
+	}
 	return &OpenWeather{
-		url:   "http://api.openweathermap.org/data/2.5/weather",
-		appID: appID,
+		url:       strings.TrimSuffix(o.baseURL, "/"),
+		appID:     appID,
+		client:    &client,
+		userAgent: o.userAgent,
 	}, nil
 }
 
 // Data -
 // DAO to receive data from upstream service
 type Data struct {
+	Dt   int64  `json:"dt"`
+	Name string `json:"name"`
 	Main struct {
-		Temp float64 `json:"temp"`
+		Temp      float64  `json:"temp"`
+		FeelsLike *float64 `json:"feels_like"`
+		Humidity  *float64 `json:"humidity"`
+		Pressure  *float64 `json:"pressure"`
 	} `json:"main"`
 	Wind struct {
-		Speed float64 `json:"speed"`
+		Speed float64  `json:"speed"`
+		Deg   *float64 `json:"deg"`
+		Gust  *float64 `json:"gust"`
 	} `json:"wind"`
+	// Visibility is in metres
+	Visibility *float64 `json:"visibility"`
+	Clouds     struct {
+		All *float64 `json:"all"`
+	} `json:"clouds"`
+	// Rain and Snow are only present when there has been some
+	Rain *struct {
+		OneHour float64 `json:"1h"`
+	} `json:"rain"`
+	Snow *struct {
+		OneHour float64 `json:"1h"`
+	} `json:"snow"`
+	Weather []struct {
+		ID          int    `json:"id"`
+		Description string `json:"description"`
+	} `json:"weather"`
 }
 
-// Allow http.Get to be faked in unit tests
-var httpGet = http.Get
+// Name -
+func (ow *OpenWeather) Name() string {
+	return Name
+}
 
-// allow ioutil.ReadAll to be faked for tests
-var ioutilReadAll = ioutil.ReadAll
+// GetWeather -
+// Kept for callers without a context or registry, the upstream call cannot be
+// cancelled and city is passed to OpenWeatherMap as it is.
+func (ow *OpenWeather) GetWeather(city string) (weather.Observation, error) {
+	return ow.GetWeatherContext(context.Background(), weather.Location{ID: weather.NewLocationID(city), Name: city})
+}
 
-// allow json.Unmarshal to be faked for tests
-var jsonUnmarshal = json.Unmarshal
+// GetWeatherContext -
+// The upstream call is abandoned when ctx is done.
+func (ow *OpenWeather) GetWeatherContext(ctx context.Context, loc weather.Location) (weather.Observation, error) {
+	owLocation, ok := ow.getLocation(loc)
+	if !ok {
+		return weather.Observation{}, fmt.Errorf("location is required, %w", weather.ErrNotSupported)
+	}
+	a := Data{}
+	if err := ow.get(ctx, "getWeather", "/data/2.5/weather", owLocation, &a); err != nil {
+		return weather.Observation{}, err
+	}
 
-// GetWeather -
-// ignore the linter warning about returning an unexported type
-// nolint:revive
-func (ow *OpenWeather) GetWeather(city string) (struct{ Temperature, WindSpeed float64 }, error) {
-	if city == "" {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("city is required")
+	location := a.Name
+	if location == "" {
+		location = loc.Name
 	}
-	owCity, ok := ow.getCity(city)
+
+	// units=metric gives celsius and metres per second
+	o := weather.Observation{
+		Temperature: a.Main.Temp,
+		WindSpeed:   a.Wind.Speed,
+		Units: weather.Units{
+			Temperature: weather.Celsius,
+			WindSpeed:   weather.MetresPerSecond,
+		},
+		ObservedAt:    time.Unix(a.Dt, 0).UTC(),
+		Provider:      Name,
+		Location:      location,
+		FeelsLike:     a.Main.FeelsLike,
+		WindGust:      a.Wind.Gust,
+		WindDirection: a.Wind.Deg,
+		Humidity:      a.Main.Humidity,
+		Pressure:      a.Main.Pressure,
+		CloudCover:    a.Clouds.All,
+	}
+	if a.Visibility != nil {
+		km := *a.Visibility / 1000
+		o.Visibility = &km
+	}
+	// no rain or snow means the sky is dry, as long as the weather is known
+	if a.Rain != nil || a.Snow != nil || len(a.Weather) > 0 {
+		precipitation := 0.0
+		if a.Rain != nil {
+			precipitation += a.Rain.OneHour
+		}
+		if a.Snow != nil {
+			precipitation += a.Snow.OneHour
+		}
+		o.Precipitation = &precipitation
+	}
+	if len(a.Weather) > 0 {
+		o.Condition = &weather.Condition{
+			Code: condition(a.Weather[0].ID),
+			Text: a.Weather[0].Description,
+		}
+	}
+	return o, nil
+}
+
+// condition -
+// Map an OpenWeatherMap weather condition id, see
+// https://openweathermap.org/weather-conditions, to the shared taxonomy.
+func condition(id int) weather.ConditionCode {
+	switch {
+	case id >= 200 && id < 300:
+		return weather.ConditionThunderstorm
+	case id >= 300 && id < 400:
+		return weather.ConditionDrizzle
+	case id == 511:
+		// freezing rain
+		return weather.ConditionSleet
+	case id >= 502 && id <= 504, id == 522:
+		return weather.ConditionHeavyRain
+	case id >= 500 && id < 600:
+		return weather.ConditionRain
+	case id >= 611 && id <= 616:
+		return weather.ConditionSleet
+	case id >= 600 && id < 700:
+		return weather.ConditionSnow
+	case id == 701, id == 741:
+		return weather.ConditionFog
+	case id == 771:
+		return weather.ConditionSquall
+	case id == 781:
+		return weather.ConditionTornado
+	case id >= 700 && id < 800:
+		// smoke, haze, sand, dust, and ash
+		return weather.ConditionHaze
+	case id == 800:
+		return weather.ConditionClear
+	case id == 801, id == 802:
+		return weather.ConditionPartlyCloudy
+	case id == 803:
+		return weather.ConditionCloudy
+	case id == 804:
+		return weather.ConditionOvercast
+	}
+	return weather.ConditionUnknown
+}
+
+// ForecastData -
+// DAO to receive the 5 day, 3 hour forecast from upstream service
+type ForecastData struct {
+	List []struct {
+		Dt   int64 `json:"dt"`
+		Main struct {
+			Temp float64 `json:"temp"`
+		} `json:"main"`
+		Wind struct {
+			Speed float64 `json:"speed"`
+			Gust  float64 `json:"gust"`
+		} `json:"wind"`
+	} `json:"list"`
+	City struct {
+		Name string `json:"name"`
+	} `json:"city"`
+}
+
+// Forecast periods are three hours apart, and run out after five days
+const (
+	forecastStep       = 3
+	maxForecastPeriods = 40
+)
+
+// GetForecastContext -
+// The upstream call is abandoned when ctx is done.
+func (ow *OpenWeather) GetForecastContext(ctx context.Context, loc weather.Location, hours int) (weather.Forecast, error) {
+	periods := (hours + forecastStep - 1) / forecastStep
+	if periods > maxForecastPeriods {
+		periods = maxForecastPeriods
+	}
+	owLocation, ok := ow.getLocation(loc)
 	if !ok {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("%q is an unknown city for this provider", city)
+		return weather.Forecast{}, fmt.Errorf("location is required, %w", weather.ErrNotSupported)
+	}
+	owLocation.Set("cnt", strconv.Itoa(periods))
+	a := ForecastData{}
+	if err := ow.get(ctx, "getForecast", "/data/2.5/forecast", owLocation, &a); err != nil {
+		return weather.Forecast{}, err
+	}
+
+	location := a.City.Name
+	if location == "" {
+		location = loc.Name
+	}
+	f := weather.Forecast{
+		Hourly: make([]weather.ForecastPeriod, len(a.List)),
+		Units: weather.Units{
+			Temperature: weather.Celsius,
+			WindSpeed:   weather.MetresPerSecond,
+		},
+		Provider: Name,
+		Location: location,
+	}
+	for i, p := range a.List {
+		f.Hourly[i] = weather.ForecastPeriod{
+			Time:        time.Unix(p.Dt, 0).UTC(),
+			Temperature: p.Main.Temp,
+			WindSpeed:   p.Wind.Speed,
+			WindGust:    p.Wind.Gust,
+		}
+	}
+	return f, nil
+}
+
+// HistoryData -
+// DAO to receive the One Call day summary from upstream service
+type HistoryData struct {
+	// TZ is the UTC offset of the day, eg. "+11:00"
+	TZ          string `json:"tz"`
+	Temperature struct {
+		Min *float64 `json:"min"`
+		Max *float64 `json:"max"`
+	} `json:"temperature"`
+}
+
+// GetHistoryContext -
+// One Call 3.0's day summary only takes coordinates. It covers the whole
+// local day, given the UTC offset at the start of date, or found from the
+// coordinates when the location has no timezone. It has no hourly periods so
+// the history is only a summary, and it has no gusts, only the strongest
+// sustained wind, so the summary has no MaxWindGust.
+// The upstream call is abandoned when ctx is done.
+func (ow *OpenWeather) GetHistoryContext(ctx context.Context, loc weather.Location, date time.Time) (weather.History, error) {
+	if !loc.HasCoordinates() {
+		return weather.History{}, fmt.Errorf("getHistory: coordinates are required, %w", weather.ErrNotSupported)
+	}
+	params := url.Values{
+		"lat":  {strconv.FormatFloat(loc.Lat, 'f', -1, 64)},
+		"lon":  {strconv.FormatFloat(loc.Lon, 'f', -1, 64)},
+		"date": {date.Format("2006-01-02")},
+	}
+	if loc.Timezone != "" {
+		params.Set("tz", date.Format("-07:00"))
+	}
+	a := HistoryData{}
+	if err := ow.get(ctx, "getHistory", "/data/3.0/onecall/day_summary", params, &a); err != nil {
+		return weather.History{}, err
+	}
+	if a.Temperature.Min == nil || a.Temperature.Max == nil {
+		return weather.History{}, fmt.Errorf("getHistory: no temperatures for %s", params.Get("date"))
+	}
+	var offset *int
+	if tz, err := time.Parse("-07:00", a.TZ); err == nil {
+		_, seconds := tz.Zone()
+		offset = &seconds
+	}
+
+	return weather.History{
+		Summary: &weather.DailyForecast{
+			Date:           params.Get("date"),
+			MinTemperature: *a.Temperature.Min,
+			MaxTemperature: *a.Temperature.Max,
+		},
+		Units: weather.Units{
+			Temperature: weather.Celsius,
+			WindSpeed:   weather.MetresPerSecond,
+		},
+		Provider:  Name,
+		Location:  loc.Name,
+		UTCOffset: offset,
+	}, nil
+}
+
+// get -
+// Call endpoint with the location and any other query parameters in params,
+// and decode the response into v. Errors are prefixed with op, and never
+// include the app id.
+func (ow *OpenWeather) get(ctx context.Context, op, endpoint string, params url.Values, v interface{}) error {
+	u, err := url.Parse(ow.url + endpoint)
+	if err != nil {
+		return fmt.Errorf("%s: building request error %w", op, err)
+	}
+	params.Set(appIDParam, ow.appID)
+	params.Set("units", "metric")
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("%s: building request error %w", op, weather.RedactError(err, appIDParam))
+	}
+	if ow.userAgent != "" {
+		req.Header.Set("User-Agent", ow.userAgent)
 	}
-	// build query string
-	query := ow.url + "?q=" + owCity + "&appid=" + ow.appID + "&units=metric"
 
 	// Make call to server
-	resp, err := httpGet(query)
+	resp, err := ow.client.Do(req)
 	if err != nil {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: http.Get error %w", err)
+		return fmt.Errorf("%s: http.Get error %w", op, weather.MarkTemporary(weather.RedactError(err, appIDParam)))
 	}
 	defer resp.Body.Close()
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: reading response error %w", op, err)
+	}
 	// Check that the server is happy with out request
 	if resp.StatusCode != http.StatusOK {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: got bad status %d", resp.StatusCode)
-	}
-	body, err := ioutilReadAll(resp.Body)
-	if err != nil {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: reading response error %w", err)
+		return fmt.Errorf("%s: %w", op, newAPIError(resp, body))
 	}
 
-	a := Data{}
-	if err := jsonUnmarshal(body, &a); err != nil {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: unmarshalling response error %w", err)
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s: unmarshalling response error %w", op, err)
 	}
-
-	return struct{ Temperature, WindSpeed float64 }{
-		Temperature: a.Main.Temp,
-		WindSpeed:   a.Wind.Speed,
-	}, nil
+	return nil
 }
 
-// Simple datastore for city name conversion
-// this could easily be an external cache with the orchestrators knowledge of
-// countries/provinces or states/cities as keys and this providers city list as
-// values
-var cities = map[string]string{"melbourne": "melbourne,AU", "sydney": "sydney,AU"}
-
-func (ow *OpenWeather) getCity(city string) (string, bool) {
-	c, ok := cities[strings.ToLower(strings.TrimSpace(city))]
-	return c, ok
+// getLocation -
+// The query parameters for loc, OpenWeatherMap accepts a city name ("q"),
+// coordinates ("lat" and "lon"), or a postcode ("zip").
+// An alias set for this provider in the registry is used as the city name
+// first, then postcode, then coordinates, and finally "name,country".
+func (ow *OpenWeather) getLocation(loc weather.Location) (url.Values, bool) {
+	if alias, ok := loc.Alias(Name); ok {
+		return url.Values{"q": {alias}}, true
+	}
+	if loc.Postcode != "" {
+		return url.Values{"zip": {loc.Postcode + "," + loc.Country}}, true
+	}
+	if loc.HasCoordinates() {
+		return url.Values{
+			"lat": {strconv.FormatFloat(loc.Lat, 'f', -1, 64)},
+			"lon": {strconv.FormatFloat(loc.Lon, 'f', -1, 64)},
+		}, true
+	}
+	if loc.Name == "" {
+		return nil, false
+	}
+	if loc.Country == "" {
+		return url.Values{"q": {loc.Name}}, true
+	}
+	return url.Values{"q": {loc.Name + "," + loc.Country}}, true
 }
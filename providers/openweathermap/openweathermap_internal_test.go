@@ -1,76 +1,149 @@
 package openweathermap
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/shanehowearth/weather"
 	"github.com/stretchr/testify/assert"
 )
 
-type fakeIOReadCloser struct{}
+// stub -
+// A provider calling a local server that answers with handler.
+func stub(t *testing.T, handler http.HandlerFunc, opts ...Option) *OpenWeather {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	ow, err := NewOpenWeather("test app ID", append([]Option{WithBaseURL(server.URL)}, opts...)...)
+	assert.Nil(t, err)
+	return ow
+}
 
-var readResponse []byte
+// roundTripFunc -
+// A transport for failures that a server cannot produce.
+type roundTripFunc func(*http.Request) (*http.Response, error)
 
-func (f *fakeIOReadCloser) Read(p []byte) (n int, err error) {
-	return len(readResponse), nil
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
 }
-func (f *fakeIOReadCloser) Close() error {
-	return nil
+
+func float(f float64) *float64 {
+	return &f
 }
 
 func TestGetWeather(t *testing.T) {
-	fakeIORC := &fakeIOReadCloser{}
+	melbourne := weather.Location{
+		ID:      "melbourne",
+		Name:    "Melbourne",
+		Country: "AU",
+		Aliases: map[string]string{Name: "melbourne,AU"},
+	}
 
 	testcases := map[string]struct {
-		city         string
-		getError     error
-		ioError      error
-		marshalError error
-		outError     error
-		expectedResp *http.Response
-		expected     struct{ Temperature, WindSpeed float64 }
-		readResponse []byte
+		loc       weather.Location
+		params    map[string]string // expected upstream query parameters for the location
+		transport http.RoundTripper // instead of the stub server
+		status    int
+		body      string
+		outError  string
+		expected  weather.Observation
 	}{
-		"no city": {
-			outError: fmt.Errorf("city is required"),
+		"no location": {
+			outError: "location is required, not supported by this provider",
+		},
+		"no alias": {
+			loc:      weather.Location{ID: "alice springs", Name: "Alice Springs", Country: "AU"},
+			params:   map[string]string{"q": "Alice Springs,AU"},
+			status:   http.StatusNotFound,
+			body:     `{"cod":"404","message":"city not found"}`,
+			outError: "getWeather: got bad status 404: city not found",
+		},
+		"coordinates": {
+			loc:      weather.Location{ID: "coord:-37.8140,144.9633", Name: "-37.8140,144.9633", Lat: -37.814, Lon: 144.9633},
+			params:   map[string]string{"lat": "-37.814", "lon": "144.9633"},
+			status:   http.StatusNotFound,
+			body:     `{"cod":"404","message":"city not found"}`,
+			outError: "getWeather: got bad status 404: city not found",
+		},
+		"null island": {
+			loc:      weather.Location{ID: "coord:0.0000,0.0000", Name: "0.0000,0.0000"},
+			params:   map[string]string{"lat": "0", "lon": "0", "q": ""},
+			status:   http.StatusNotFound,
+			body:     `{"cod":"404","message":"city not found"}`,
+			outError: "getWeather: got bad status 404: city not found",
 		},
-		"no city with that name": {
-			city:     "non-existant",
-			outError: fmt.Errorf("'non-existant' is an unknown city for this provider"),
+		"reserved characters": {
+			loc:      weather.Location{ID: "x", Name: "Rock & Roll #1?appid=other", Country: "US"},
+			params:   map[string]string{"q": "Rock & Roll #1?appid=other,US", "appid": "test app ID"},
+			status:   http.StatusNotFound,
+			body:     `{"cod":"404","message":"city not found"}`,
+			outError: "getWeather: got bad status 404: city not found",
+		},
+		"postcode": {
+			loc:      weather.Location{ID: "postcode:3000,au", Name: "3000", Postcode: "3000", Country: "AU"},
+			params:   map[string]string{"zip": "3000,AU"},
+			status:   http.StatusNotFound,
+			body:     `{"cod":"404","message":"city not found"}`,
+			outError: "getWeather: got bad status 404: city not found",
 		},
 		"http error": {
-			city:     "melbourne",
-			getError: fmt.Errorf("fake response error"),
-			outError: fmt.Errorf("fake response error"),
+			loc: melbourne,
+			transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("fake response error")
+			}),
+			outError: `getWeather: http.Get error Get "https://api.openweathermap.org/data/2.5/weather?appid=REDACTED&q=melbourne%2CAU&units=metric": fake response error`,
 		},
 		"io error": {
-			city:         "melbourne",
-			ioError:      fmt.Errorf("fake io error"),
-			expectedResp: &http.Response{Body: fakeIORC, Status: "200 OK", StatusCode: http.StatusOK},
-			outError:     fmt.Errorf("getWeather: reading response error fake io error"),
+			loc: melbourne,
+			transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(iotest.ErrReader(fmt.Errorf("fake io error")))}, nil
+			}),
+			outError: "getWeather: reading response error fake io error",
 		},
 		"upstream error": {
-			city:         "melbourne",
-			expectedResp: &http.Response{Body: fakeIORC, StatusCode: http.StatusBadRequest},
-			outError:     fmt.Errorf("getWeather: got bad status 400"),
+			loc:      melbourne,
+			status:   http.StatusBadRequest,
+			outError: "getWeather: got bad status 400",
 		},
 		"json error": {
-			city:         "melbourne",
-			marshalError: fmt.Errorf("fake json error"),
-			expectedResp: &http.Response{Body: fakeIORC, Status: "200 OK", StatusCode: http.StatusOK},
-			outError:     fmt.Errorf("getWeather: unmarshalling response error fake response error"),
+			loc:      melbourne,
+			status:   http.StatusOK,
+			body:     `{`,
+			outError: "getWeather: unmarshalling response error unexpected end of JSON input",
 		},
 		"melbourne": {
-			city:         "melbourne",
-			expectedResp: &http.Response{Body: fakeIORC, Status: "200 OK", StatusCode: http.StatusOK},
-			expected: struct{ Temperature, WindSpeed float64 }{
+			loc:    melbourne,
+			params: map[string]string{"q": "melbourne,AU"},
+			status: http.StatusOK,
+			expected: weather.Observation{
 				Temperature: float64(15.48),
 				WindSpeed:   float64(2.68),
+				Units: weather.Units{
+					Temperature: weather.Celsius,
+					WindSpeed:   weather.MetresPerSecond,
+				},
+				ObservedAt:    time.Unix(1636614234, 0).UTC(),
+				Provider:      Name,
+				Location:      "Melbourne",
+				FeelsLike:     float(14.6),
+				WindGust:      float(6.71),
+				WindDirection: float(139),
+				Humidity:      float(58),
+				Pressure:      float(1001),
+				Visibility:    float(10),
+				CloudCover:    float(75),
+				Precipitation: float(0),
+				Condition:     &weather.Condition{Code: weather.ConditionCloudy, Text: "broken clouds"},
 			},
-			readResponse: []byte(`{
+			body: `{
     "base": "stations",
     "clouds": {
         "all": 75
@@ -113,37 +186,384 @@ func TestGetWeather(t *testing.T) {
         "gust": 6.71,
         "speed": 2.68
     }
-}`),
+}`,
 		},
 	}
 	for name, tc := range testcases {
 		t.Run(name, func(t *testing.T) {
 			// Set up
-			httpGet = func(url string) (resp *http.Response, err error) {
-				return tc.expectedResp, tc.getError
+			ow := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/data/2.5/weather", r.URL.Path)
+				for k, v := range tc.params {
+					assert.Equal(t, v, r.URL.Query().Get(k))
+				}
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+			if tc.transport != nil {
+				var err error
+				ow, err = NewOpenWeather("test app ID", WithTransport(tc.transport))
+				assert.Nil(t, err)
 			}
 
-			ioutilReadAll = func(r io.Reader) ([]byte, error) {
-				return tc.readResponse, tc.ioError
+			// Test
+			output, err := ow.GetWeatherContext(context.Background(), tc.loc)
+
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+				return
 			}
-			jsonUnmarshal = func(data []byte, v interface{}) error {
-				if tc.marshalError == nil {
-					return json.Unmarshal(data, v)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestGetForecast(t *testing.T) {
+	melbourne := weather.Location{ID: "melbourne", Name: "Melbourne", Aliases: map[string]string{Name: "melbourne,AU"}}
+
+	testcases := map[string]struct {
+		loc      weather.Location
+		hours    int
+		params   map[string]string
+		status   int
+		body     string
+		outError string
+		expected weather.Forecast
+	}{
+		"no location": {
+			hours:    24,
+			outError: "location is required, not supported by this provider",
+		},
+		"upstream error": {
+			loc:      melbourne,
+			hours:    24,
+			status:   http.StatusUnauthorized,
+			outError: "getForecast: got bad status 401",
+		},
+		"melbourne": {
+			loc:    melbourne,
+			hours:  5,
+			params: map[string]string{"q": "melbourne,AU", "cnt": "2"},
+			status: http.StatusOK,
+			body: `{"cod":"200","cnt":2,"list":[
+				{"dt":1636621200,"main":{"temp":14.2},"wind":{"speed":3.1,"gust":7.4}},
+				{"dt":1636632000,"main":{"temp":12.9},"wind":{"speed":2.6}}],
+				"city":{"name":"Melbourne","timezone":39600}}`,
+			expected: weather.Forecast{
+				Hourly: []weather.ForecastPeriod{
+					{Time: time.Unix(1636621200, 0).UTC(), Temperature: 14.2, WindSpeed: 3.1, WindGust: 7.4},
+					{Time: time.Unix(1636632000, 0).UTC(), Temperature: 12.9, WindSpeed: 2.6},
+				},
+				Units:    weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond},
+				Provider: Name,
+				Location: "Melbourne",
+			},
+		},
+		"at most five days": {
+			loc:    melbourne,
+			hours:  1000,
+			params: map[string]string{"cnt": "40"},
+			status: http.StatusOK,
+			body:   `{"list":[]}`,
+			expected: weather.Forecast{
+				Hourly:   []weather.ForecastPeriod{},
+				Units:    weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond},
+				Provider: Name,
+				Location: "Melbourne",
+			},
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ow := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/data/2.5/forecast", r.URL.Path)
+				for k, v := range tc.params {
+					assert.Equal(t, v, r.URL.Query().Get(k))
 				}
-				return tc.marshalError
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			output, err := ow.GetForecastContext(context.Background(), tc.loc, tc.hours)
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+				return
 			}
-			ow, err := NewOpenWeather("test app ID")
 			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
 
-			// Test
-			output, err := ow.GetWeather(tc.city)
+func TestGetHistory(t *testing.T) {
+	date := time.Date(2021, 11, 10, 0, 0, 0, 0, time.FixedZone("AEDT", 11*60*60))
+	aedt := 11 * 60 * 60
 
-			if tc.outError == nil {
-				assert.Nil(t, err)
-				assert.Equal(t, tc.expected, output)
-			} else {
-				assert.NotNil(t, err)
+	testcases := map[string]struct {
+		loc      weather.Location
+		tz       string
+		status   int
+		body     string
+		outError string
+		expected weather.History
+	}{
+		"no coordinates": {
+			loc:      weather.Location{ID: "melbourne", Name: "Melbourne"},
+			outError: "getHistory: coordinates are required, not supported by this provider",
+		},
+		"upstream error": {
+			loc:      weather.Location{ID: "melbourne", Name: "Melbourne", Lat: -37.814, Lon: 144.9633, Timezone: "Australia/Melbourne"},
+			tz:       "+11:00",
+			status:   http.StatusBadRequest,
+			outError: "getHistory: got bad status 400",
+		},
+		"no temperatures": {
+			loc:      weather.Location{ID: "melbourne", Name: "Melbourne", Lat: -37.814, Lon: 144.9633, Timezone: "Australia/Melbourne"},
+			tz:       "+11:00",
+			status:   http.StatusOK,
+			body:     `{"lat":-37.814,"lon":144.9633,"tz":"+11:00","date":"2021-11-10","units":"metric"}`,
+			outError: "getHistory: no temperatures for 2021-11-10",
+		},
+		"melbourne": {
+			loc:    weather.Location{ID: "melbourne", Name: "Melbourne", Lat: -37.814, Lon: 144.9633, Timezone: "Australia/Melbourne"},
+			tz:     "+11:00",
+			status: http.StatusOK,
+			body: `{"lat":-37.814,"lon":144.9633,"tz":"+11:00","date":"2021-11-10","units":"metric",
+				"cloud_cover":{"afternoon":40},"humidity":{"afternoon":55},"precipitation":{"total":0.2},
+				"temperature":{"min":11.3,"max":21.6,"afternoon":20.9,"night":12.1,"evening":17.4,"morning":13.2},
+				"pressure":{"afternoon":1016},"wind":{"max":{"speed":8.2,"direction":200}}}`,
+			expected: weather.History{
+				Summary:   &weather.DailyForecast{Date: "2021-11-10", MinTemperature: 11.3, MaxTemperature: 21.6},
+				Units:     weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond},
+				Provider:  Name,
+				Location:  "Melbourne",
+				UTCOffset: &aedt,
+			},
+		},
+		"coordinates only": {
+			loc:    weather.Location{ID: "melbourne", Name: "Melbourne", Lat: -37.814, Lon: 144.9633},
+			status: http.StatusOK,
+			body: `{"lat":-37.814,"lon":144.9633,"tz":"+11:00","date":"2021-11-10","units":"metric",
+				"cloud_cover":{"afternoon":40},"humidity":{"afternoon":55},"precipitation":{"total":0.2},
+				"temperature":{"min":11.3,"max":21.6,"afternoon":20.9,"night":12.1,"evening":17.4,"morning":13.2},
+				"pressure":{"afternoon":1016},"wind":{"max":{"speed":8.2,"direction":200}}}`,
+			expected: weather.History{
+				Summary:   &weather.DailyForecast{Date: "2021-11-10", MinTemperature: 11.3, MaxTemperature: 21.6},
+				Units:     weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond},
+				Provider:  Name,
+				Location:  "Melbourne",
+				UTCOffset: &aedt,
+			},
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ow := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/data/3.0/onecall/day_summary", r.URL.Path)
+				assert.Equal(t, "-37.814", r.URL.Query().Get("lat"))
+				assert.Equal(t, "144.9633", r.URL.Query().Get("lon"))
+				// the local day in Melbourne
+				assert.Equal(t, "2021-11-10", r.URL.Query().Get("date"))
+				// without a timezone upstream finds the offset itself
+				assert.Equal(t, tc.tz, r.URL.Query().Get("tz"))
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			output, err := ow.GetHistoryContext(context.Background(), tc.loc, date)
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestCondition(t *testing.T) {
+	testcases := map[int]weather.ConditionCode{
+		211: weather.ConditionThunderstorm,
+		301: weather.ConditionDrizzle,
+		500: weather.ConditionRain,
+		503: weather.ConditionHeavyRain,
+		511: weather.ConditionSleet,
+		601: weather.ConditionSnow,
+		613: weather.ConditionSleet,
+		721: weather.ConditionHaze,
+		741: weather.ConditionFog,
+		781: weather.ConditionTornado,
+		800: weather.ConditionClear,
+		802: weather.ConditionPartlyCloudy,
+		804: weather.ConditionOvercast,
+		999: weather.ConditionUnknown,
+	}
+	for id, expected := range testcases {
+		assert.Equal(t, expected, condition(id), "id %d", id)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	testcases := map[string]struct {
+		status     int
+		retryAfter string
+		body       string
+		outError   string
+		kind       error
+		wait       time.Duration // from Retry-After, if set
+	}{
+		"invalid key": {
+			status:   http.StatusUnauthorized,
+			body:     `{"cod":401, "message": "Invalid API key. Please see http://openweathermap.org/faq#error401 for more info."}`,
+			outError: "getWeather: got bad status 401: Invalid API key. Please see http://openweathermap.org/faq#error401 for more info.",
+			kind:     weather.ErrAuth,
+		},
+		"rate limited": {
+			status:   http.StatusTooManyRequests,
+			body:     `{"cod":429, "message": "Your account is temporary blocked due to exceeding of requests limitation of your subscription type."}`,
+			outError: "getWeather: got bad status 429: Your account is temporary blocked due to exceeding of requests limitation of your subscription type.",
+			kind:     weather.ErrRateLimited,
+		},
+		"rate limited with retry after": {
+			status:     http.StatusTooManyRequests,
+			retryAfter: "30",
+			body:       `{"cod":429, "message": "Too many requests"}`,
+			outError:   "getWeather: got bad status 429: Too many requests",
+			kind:       weather.ErrRateLimited,
+			wait:       30 * time.Second,
+		},
+		"unknown city": {
+			status:   http.StatusNotFound,
+			body:     `{"cod":"404","message":"city not found"}`,
+			outError: "getWeather: got bad status 404: city not found",
+			kind:     weather.ErrUnknownLocation,
+		},
+		"unavailable": {
+			status:   http.StatusBadGateway,
+			body:     `<html><body>502 Bad Gateway</body></html>`,
+			outError: "getWeather: got bad status 502",
+			kind:     weather.ErrUnavailable,
+		},
+		"bad request": {
+			status:   http.StatusBadRequest,
+			body:     `{"cod":"400","message":"wrong latitude"}`,
+			outError: "getWeather: got bad status 400: wrong latitude",
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ow := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			_, err := ow.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+			assert.EqualError(t, err, tc.outError)
+
+			var apiErr *APIError
+			assert.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tc.status, apiErr.StatusCode)
+			for _, kind := range []error{weather.ErrAuth, weather.ErrRateLimited, weather.ErrUnknownLocation, weather.ErrUnavailable} {
+				assert.Equal(t, kind == tc.kind, errors.Is(err, kind), kind.Error())
 			}
+			wait, ok := weather.RetryAfter(err)
+			assert.Equal(t, tc.wait != 0, ok)
+			assert.Equal(t, tc.wait, wait)
 		})
 	}
 }
+
+func TestTransportErrorIsRedacted(t *testing.T) {
+	ow, err := NewOpenWeather("s3cret/app+id", WithTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, context.DeadlineExceeded
+	})))
+	assert.Nil(t, err)
+
+	_, err = ow.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.EqualError(t, err, `getWeather: http.Get error Get "https://api.openweathermap.org/data/2.5/weather?appid=REDACTED&q=Melbourne&units=metric": context deadline exceeded`)
+	assert.False(t, strings.Contains(err.Error(), "s3cret"))
+	assert.True(t, errors.Is(err, weather.ErrTemporary))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestOptions(t *testing.T) {
+	// a user agent
+	ow := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "weather-test/1.0", r.Header.Get("User-Agent"))
+		fmt.Fprint(w, `{"name":"Melbourne"}`)
+	}, WithUserAgent("weather-test/1.0"))
+	_, err := ow.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.Nil(t, err)
+
+	// a timeout
+	release := make(chan struct{})
+	ow = stub(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}, WithTimeout(10*time.Millisecond))
+	_, err = ow.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	close(release)
+	assert.True(t, errors.Is(err, weather.ErrTemporary))
+	var urlErr *url.Error
+	assert.True(t, errors.As(err, &urlErr))
+	assert.True(t, urlErr.Timeout())
+
+	// a client of our own, which the other options do not change
+	calls := 0
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`{}`))}, nil
+	})}
+	ow, err = NewOpenWeather("test app ID", WithHTTPClient(client), WithTimeout(time.Second))
+	assert.Nil(t, err)
+	_, err = ow.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, time.Duration(0), client.Timeout)
+}
+
+func TestContextReachesUpstream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ow := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		// abandoned by the caller, the request is cancelled upstream too
+		cancel()
+		<-r.Context().Done()
+	})
+	_, err := ow.GetWeatherContext(ctx, weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+// counted counts the history calls that get through the wrappers
+type counted struct {
+	*OpenWeather
+	calls int
+}
+
+func (c *counted) GetHistoryContext(ctx context.Context, loc weather.Location, date time.Time) (weather.History, error) {
+	c.calls++
+	return c.OpenWeather.GetHistoryContext(ctx, loc, date)
+}
+
+func TestNoCoordinatesIsNotAFailure(t *testing.T) {
+	c := &counted{OpenWeather: stub(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no call should be made without coordinates")
+	})}
+	limited, err := weather.WithLimit(weather.WithTimeout(c, time.Second), weather.LimitPolicy{}, nil)
+	assert.Nil(t, err)
+	retried, err := weather.WithRetry(limited, weather.RetryPolicy{Attempts: 3, BudgetBurst: 10})
+	assert.Nil(t, err)
+	breaker, err := weather.WithBreaker(retried, weather.DefaultBreakerPolicy)
+	assert.Nil(t, err)
+
+	loc := weather.Location{ID: "postcode:3000,au", Name: "3000", Postcode: "3000", Country: "AU"}
+	for i := 0; i < 2*weather.DefaultBreakerPolicy.FailureThreshold; i++ {
+		_, err = breaker.GetHistoryContext(context.Background(), loc, time.Date(2021, 11, 10, 0, 0, 0, 0, time.UTC))
+		assert.True(t, errors.Is(err, weather.ErrNotSupported))
+	}
+	// never retried, and the breaker stays closed for everything else
+	assert.Equal(t, 2*weather.DefaultBreakerPolicy.FailureThreshold, c.calls)
+	assert.Equal(t, weather.BreakerClosed, breaker.State())
+}
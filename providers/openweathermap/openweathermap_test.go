@@ -3,6 +3,7 @@ package openweathermap_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/shanehowearth/weather/providers/openweathermap"
 	"github.com/stretchr/testify/assert"
@@ -12,23 +13,42 @@ func TestNewOpenWeather(t *testing.T) {
 
 	testcases := map[string]struct {
 		appID string
+		opts  []openweathermap.Option
 		err   error
 	}{
 		"successful creation": {
 			appID: "test api key",
 		},
+		"with options": {
+			appID: "test api key",
+			opts: []openweathermap.Option{
+				openweathermap.WithBaseURL("http://localhost:8081/"),
+				openweathermap.WithTimeout(5 * time.Second),
+				openweathermap.WithUserAgent("weather/1.0"),
+			},
+		},
 		"no appID": {
 			err: fmt.Errorf("appID is required"),
 		},
+		"relative base URL": {
+			appID: "test api key",
+			opts:  []openweathermap.Option{openweathermap.WithBaseURL("/data")},
+			err:   fmt.Errorf("base URL must be absolute, got \"/data\""),
+		},
+		"negative timeout": {
+			appID: "test api key",
+			opts:  []openweathermap.Option{openweathermap.WithTimeout(-time.Second)},
+			err:   fmt.Errorf("timeout cannot be negative"),
+		},
 	}
 	for name, tc := range testcases {
 		t.Run(name, func(t *testing.T) {
-			ow, err := openweathermap.NewOpenWeather(tc.appID)
+			ow, err := openweathermap.NewOpenWeather(tc.appID, tc.opts...)
 			if tc.err == nil {
 				assert.Nil(t, err)
 				assert.NotNil(t, ow)
 			} else {
-				assert.NotNil(t, err)
+				assert.EqualError(t, err, tc.err.Error())
 			}
 		})
 	}
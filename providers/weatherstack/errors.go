@@ -0,0 +1,109 @@
+package weatherstack
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shanehowearth/weather"
+)
+
+// APIError -
+// A failed request. Weatherstack reports most failures with 200 OK and a body
+// such as
+//
+//	{"success":false,"error":{"code":101,"type":"invalid_access_key","info":"..."}}
+//
+// see https://weatherstack.com/documentation#api_error_codes. Any other status
+// is reported with just the StatusCode.
+type APIError struct {
+	StatusCode int
+	Code       int
+	Type       string
+	Info       string
+	// retryAfter is from the Retry-After header, if any
+	retryAfter *time.Duration
+}
+
+// Error -
+func (e *APIError) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("got bad status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("error %d %s: %s", e.Code, e.Type, e.Info)
+}
+
+// Unwrap -
+// The kind of failure, if it is one the weather package knows about.
+func (e *APIError) Unwrap() error {
+	switch e.Code {
+	case 0:
+		switch {
+		case e.StatusCode == http.StatusTooManyRequests:
+			return weather.ErrRateLimited
+		case e.StatusCode >= http.StatusInternalServerError:
+			return weather.ErrUnavailable
+		}
+	case 101, 102:
+		// missing, invalid, or inactive access key
+		return weather.ErrAuth
+	case 104:
+		// the plan's monthly usage limit
+		return weather.ErrQuotaExceeded
+	case 105, 603, 609:
+		if e.Type == "https_access_restricted" {
+			// the plan only allows plain HTTP, every call fails until the
+			// base URL is changed
+			return weather.ErrAuth
+		}
+		// the plan does not include the endpoint, eg. history or forecasts
+		return weather.ErrNotSupported
+	case 601, 615:
+		// the query is missing or could not be found
+		return weather.ErrUnknownLocation
+	}
+	return nil
+}
+
+// RetryAfter -
+// How long Weatherstack asked to be left alone for, if it said.
+func (e *APIError) RetryAfter() (time.Duration, bool) {
+	if e.retryAfter == nil {
+		return 0, false
+	}
+	return *e.retryAfter, true
+}
+
+// statusError -
+// The error for a response with a status other than 200 OK.
+func statusError(resp *http.Response) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode}
+	if wait, ok := weather.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		e.retryAfter = &wait
+	}
+	return e
+}
+
+// envelope -
+// The fields of every response that report failure.
+type envelope struct {
+	Success *bool `json:"success"`
+	Error   *struct {
+		Code int    `json:"code"`
+		Type string `json:"type"`
+		Info string `json:"info"`
+	} `json:"error"`
+}
+
+// err -
+// The failure reported, or nil for a successful response.
+func (e envelope) err() error {
+	if e.Error == nil && (e.Success == nil || *e.Success) {
+		return nil
+	}
+	apiErr := &APIError{StatusCode: http.StatusOK}
+	if e.Error != nil {
+		apiErr.Code, apiErr.Type, apiErr.Info = e.Error.Code, e.Error.Type, e.Error.Info
+	}
+	return apiErr
+}
@@ -1,101 +1,465 @@
 package weatherstack
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/shanehowearth/weather"
+	"github.com/shanehowearth/weather/providers/lenient"
 )
 
+// Name - identifies this provider in observations and errors
+const Name = "weatherstack"
+
+// The query parameter carrying the access key, redacted from errors
+const accessKeyParam = "access_key"
+
+// The API called unless WithBaseURL is given. The free plan only answers
+// over plain HTTP, and refuses HTTPS calls as ErrAuth, so it needs
+// WithBaseURL("http://api.weatherstack.com"), which sends the access key in
+// the clear.
+const defaultURL = "https://api.weatherstack.com"
+
 // WeatherStack -
 type WeatherStack struct {
 	url       string
 	accessKey string
+	client    *http.Client
+	userAgent string
+}
+
+// Option -
+// Configures the provider created by NewWeatherStack.
+type Option func(*options)
+
+type options struct {
+	client    *http.Client
+	transport http.RoundTripper
+	baseURL   string
+	timeout   time.Duration
+	userAgent string
+}
+
+// WithHTTPClient -
+// Make calls with c, eg. one set up for proxies or TLS. The default is a
+// client like http.DefaultClient. The other options apply to a copy, c itself
+// is not changed.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithTransport -
+// Make calls through rt, eg. an *http.Transport with its own connection
+// pooling.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = rt
+	}
+}
+
+// WithBaseURL -
+// Call the API at u instead of Weatherstack, eg. a local stub server.
+func WithBaseURL(u string) Option {
+	return func(o *options) {
+		o.baseURL = u
+	}
+}
+
+// WithTimeout -
+// The longest a call may take, including reading the response. The default
+// is no limit beyond the caller's context.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithUserAgent -
+// Send ua as the User-Agent of every call.
+func WithUserAgent(ua string) Option {
+	return func(o *options) {
+		o.userAgent = ua
+	}
 }
 
 // NewWeatherStack -
-func NewWeatherStack(accessKey string) (*WeatherStack, error) {
+func NewWeatherStack(accessKey string, opts ...Option) (*WeatherStack, error) {
 	if accessKey == "" {
 		return nil, fmt.Errorf("accessKey is required")
 	}
+	o := options{baseURL: defaultURL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if u, err := url.Parse(o.baseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base URL must be absolute, got %q", o.baseURL)
+	}
+	if o.timeout < 0 {
+		return nil, fmt.Errorf("timeout cannot be negative")
+	}
+	client := http.Client{}
+	if o.client != nil {
+		client = *o.client
+	}
+	if o.transport != nil {
+		client.Transport = o.transport
+	}
+	if o.timeout > 0 {
+		client.Timeout = o.timeout
+	}
 	return &WeatherStack{
-		url:       "http://api.weatherstack.com/current",
+		url:       strings.TrimSuffix(o.baseURL, "/"),
 		accessKey: accessKey,
+		client:    &client,
+		userAgent: o.userAgent,
 	}, nil
 }
 
 // Data -
 // Data Access Object
+// Measurements are usually whole numbers, but decimals and numbers in strings
+// turn up too.
 type Data struct {
 	Current struct {
-		Temperature int `json:"temperature"`
-		WindSpeed   int `json:"wind_speed"`
+		Temperature         lenient.Number  `json:"temperature"`
+		WindSpeed           lenient.Number  `json:"wind_speed"`
+		WindDegree          *lenient.Number `json:"wind_degree"`
+		Pressure            *lenient.Number `json:"pressure"`
+		Precip              *lenient.Number `json:"precip"`
+		Humidity            *lenient.Number `json:"humidity"`
+		CloudCover          *lenient.Number `json:"cloudcover"`
+		FeelsLike           *lenient.Number `json:"feelslike"`
+		Visibility          *lenient.Number `json:"visibility"`
+		WeatherCode         *int            `json:"weather_code"`
+		WeatherDescriptions []string        `json:"weather_descriptions"`
 	} `json:"current"`
+	Location struct {
+		Name           string `json:"name"`
+		LocaltimeEpoch int64  `json:"localtime_epoch"`
+	} `json:"location"`
+}
+
+// Name -
+func (ws *WeatherStack) Name() string {
+	return Name
 }
 
-// Allow http.Get to be faked in unit tests
-var httpGet = http.Get
+// GetWeather -
+// Kept for callers without a context or registry, the upstream call cannot be
+// cancelled and city is passed to Weatherstack as it is.
+func (ws *WeatherStack) GetWeather(city string) (weather.Observation, error) {
+	return ws.GetWeatherContext(context.Background(), weather.Location{ID: weather.NewLocationID(city), Name: city})
+}
 
-// allow ioutil.ReadAll to be faked for tests
-var ioutilReadAll = ioutil.ReadAll
+// GetWeatherContext -
+// The upstream call is abandoned when ctx is done.
+func (ws *WeatherStack) GetWeatherContext(ctx context.Context, loc weather.Location) (weather.Observation, error) {
+	a := Data{}
+	if err := ws.get(ctx, "getWeather", "/current", loc, url.Values{}, &a); err != nil {
+		return weather.Observation{}, err
+	}
 
-// allow json.Unmarshal to be faked for tests
-var jsonUnmarshal = json.Unmarshal
+	location := a.Location.Name
+	if location == "" {
+		location = loc.Name
+	}
 
-// GetWeather -
-// ignore the linter warning about returning an unexported type
-// nolint:revive
-func (ws *WeatherStack) GetWeather(city string) (struct {
-	Temperature, WindSpeed float64
-}, error) {
-	if city == "" {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("city is required")
-	}
-	wsCity, ok := ws.getCity(city)
+	// units=m gives celsius and kilometres per hour
+	// observation_time carries no date, so the localtime of the response is
+	// used instead
+	// pressure is in millibars, the same as hectopascals, and Weatherstack
+	// does not report gusts
+	o := weather.Observation{
+		Temperature: a.Current.Temperature.Float64(),
+		WindSpeed:   a.Current.WindSpeed.Float64(),
+		Units: weather.Units{
+			Temperature: weather.Celsius,
+			WindSpeed:   weather.KilometresPerHour,
+		},
+		ObservedAt:    time.Unix(a.Location.LocaltimeEpoch, 0).UTC(),
+		Provider:      Name,
+		Location:      location,
+		FeelsLike:     lenient.Optional(a.Current.FeelsLike),
+		WindDirection: lenient.Optional(a.Current.WindDegree),
+		Humidity:      lenient.Optional(a.Current.Humidity),
+		Pressure:      lenient.Optional(a.Current.Pressure),
+		Visibility:    lenient.Optional(a.Current.Visibility),
+		CloudCover:    lenient.Optional(a.Current.CloudCover),
+		Precipitation: lenient.Optional(a.Current.Precip),
+	}
+	if a.Current.WeatherCode != nil {
+		o.Condition = &weather.Condition{Code: condition(*a.Current.WeatherCode)}
+		if len(a.Current.WeatherDescriptions) > 0 {
+			o.Condition.Text = a.Current.WeatherDescriptions[0]
+		}
+	}
+	return o, nil
+}
+
+// condition -
+// Map a Weatherstack weather code, see
+// https://weatherstack.com/site_resources/weatherstack-weather-condition-codes.zip,
+// to the shared taxonomy.
+func condition(code int) weather.ConditionCode {
+	switch code {
+	case 113:
+		return weather.ConditionClear
+	case 116:
+		return weather.ConditionPartlyCloudy
+	case 119:
+		return weather.ConditionCloudy
+	case 122:
+		return weather.ConditionOvercast
+	case 143, 248, 260:
+		return weather.ConditionFog
+	case 185, 263, 266, 281, 284:
+		return weather.ConditionDrizzle
+	case 176, 293, 296, 299, 302, 353:
+		return weather.ConditionRain
+	case 305, 308, 356, 359:
+		return weather.ConditionHeavyRain
+	case 182, 311, 314, 317, 320, 350, 362, 365, 374, 377:
+		return weather.ConditionSleet
+	case 179, 227, 230, 323, 326, 329, 332, 335, 338, 368, 371:
+		return weather.ConditionSnow
+	case 200, 386, 389, 392, 395:
+		return weather.ConditionThunderstorm
+	}
+	return weather.ConditionUnknown
+}
+
+// ForecastData -
+// Data Access Object for the forecast endpoint.
+type ForecastData struct {
+	Location struct {
+		Name      string `json:"name"`
+		UTCOffset string `json:"utc_offset"`
+	} `json:"location"`
+	Forecast map[string]Day `json:"forecast"`
+}
+
+// HistoricalData -
+// Data Access Object for the historical endpoint.
+type HistoricalData struct {
+	Location struct {
+		Name      string `json:"name"`
+		UTCOffset string `json:"utc_offset"`
+	} `json:"location"`
+	Historical map[string]Day `json:"historical"`
+}
+
+// Day -
+// The forecast or history for a day, days are keyed by their local date, and
+// hours given as local "hmm", eg. "0" or "1500".
+type Day struct {
+	Hourly []struct {
+		Time        string         `json:"time"`
+		Temperature lenient.Number `json:"temperature"`
+		WindSpeed   lenient.Number `json:"wind_speed"`
+		WindGust    lenient.Number `json:"windgust"`
+	} `json:"hourly"`
+}
+
+// Weatherstack forecasts at most 14 days ahead
+const maxForecastDays = 14
+
+// GetForecastContext -
+// Hourly periods are asked for. The upstream call is abandoned when ctx is
+// done.
+func (ws *WeatherStack) GetForecastContext(ctx context.Context, loc weather.Location, hours int) (weather.Forecast, error) {
+	// today is the first day
+	days := hours/24 + 1
+	if days > maxForecastDays {
+		days = maxForecastDays
+	}
+	a := ForecastData{}
+	extra := url.Values{"forecast_days": {strconv.Itoa(days)}, "hourly": {"1"}, "interval": {"1"}}
+	if err := ws.get(ctx, "getForecast", "/forecast", loc, extra, &a); err != nil {
+		return weather.Forecast{}, err
+	}
+
+	hourly, err := periods(a.Forecast, a.Location.UTCOffset)
+	if err != nil {
+		return weather.Forecast{}, fmt.Errorf("getForecast: %w", err)
+	}
+
+	location := a.Location.Name
+	if location == "" {
+		location = loc.Name
+	}
+	return weather.Forecast{
+		Hourly: hourly,
+		Units: weather.Units{
+			Temperature: weather.Celsius,
+			WindSpeed:   weather.KilometresPerHour,
+		},
+		Provider: Name,
+		Location: location,
+	}, nil
+}
+
+// GetHistoryContext -
+// Hourly periods are asked for. The upstream call is abandoned when ctx is
+// done.
+func (ws *WeatherStack) GetHistoryContext(ctx context.Context, loc weather.Location, date time.Time) (weather.History, error) {
+	a := HistoricalData{}
+	extra := url.Values{"historical_date": {date.Format("2006-01-02")}, "hourly": {"1"}, "interval": {"1"}}
+	if err := ws.get(ctx, "getHistory", "/historical", loc, extra, &a); err != nil {
+		return weather.History{}, err
+	}
+	hourly, err := periods(a.Historical, a.Location.UTCOffset)
+	if err != nil {
+		return weather.History{}, fmt.Errorf("getHistory: %w", err)
+	}
+	// periods has already checked the offset
+	offset, _ := utcOffset(a.Location.UTCOffset)
+
+	location := a.Location.Name
+	if location == "" {
+		location = loc.Name
+	}
+	return weather.History{
+		Hourly: hourly,
+		Units: weather.Units{
+			Temperature: weather.Celsius,
+			WindSpeed:   weather.KilometresPerHour,
+		},
+		Provider:  Name,
+		Location:  location,
+		UTCOffset: &offset,
+	}, nil
+}
+
+// periods -
+// The hours of days in time order, utcOffset is the local offset in hours,
+// eg. "11.0".
+func periods(days map[string]Day, offset string) ([]weather.ForecastPeriod, error) {
+	seconds, err := utcOffset(offset)
+	if err != nil {
+		return nil, err
+	}
+	local := time.FixedZone("", seconds)
+
+	hourly := []weather.ForecastPeriod{}
+	for date, day := range days {
+		for _, h := range day.Hourly {
+			at, err := time.ParseInLocation("2006-01-02 1504", fmt.Sprintf("%s %04s", date, h.Time), local)
+			if err != nil {
+				return nil, fmt.Errorf("bad time %q on %q", h.Time, date)
+			}
+			hourly = append(hourly, weather.ForecastPeriod{
+				Time:        at.UTC(),
+				Temperature: h.Temperature.Float64(),
+				WindSpeed:   h.WindSpeed.Float64(),
+				WindGust:    h.WindGust.Float64(),
+			})
+		}
+	}
+	// days arrive in a map
+	sort.Slice(hourly, func(i, j int) bool {
+		return hourly[i].Time.Before(hourly[j].Time)
+	})
+	return hourly, nil
+}
+
+// utcOffset -
+// The offset in hours, eg. "11.0" or "5.5", as seconds.
+func utcOffset(hours string) (int, error) {
+	offset, err := strconv.ParseFloat(hours, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad utc_offset %q", hours)
+	}
+	return int(offset * float64(time.Hour/time.Second)), nil
+}
+
+// get -
+// Call endpoint for loc, with any extra query parameters, and decode the
+// response into v. Errors are prefixed with op, and never include the access
+// key.
+func (ws *WeatherStack) get(ctx context.Context, op, endpoint string, loc weather.Location, extra url.Values, v interface{}) error {
+	wsCity, ok := ws.getCity(loc)
 	if !ok {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("%q is an unknown city for this provider", city)
+		return fmt.Errorf("location is required, %w", weather.ErrNotSupported)
 	}
 
-	// build query string - note units are hardcoded to metric
-	query := ws.url + "?query=" + wsCity + "&access_key=" + ws.accessKey + "&units=m"
+	u, err := url.Parse(ws.url + endpoint)
+	if err != nil {
+		return fmt.Errorf("%s: building request error %w", op, err)
+	}
+	// note units are hardcoded to metric
+	extra.Set("query", wsCity)
+	extra.Set(accessKeyParam, ws.accessKey)
+	extra.Set("units", "m")
+	u.RawQuery = extra.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("%s: building request error %w", op, weather.RedactError(err, accessKeyParam))
+	}
+	if ws.userAgent != "" {
+		req.Header.Set("User-Agent", ws.userAgent)
+	}
 
 	// Make call to server
-	resp, err := httpGet(query)
+	resp, err := ws.client.Do(req)
 	if err != nil {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: http.Get error %w", err)
+		return fmt.Errorf("%s: http.Get error %w", op, weather.MarkTemporary(weather.RedactError(err, accessKeyParam)))
 	}
 	defer resp.Body.Close()
 
 	// Check that the server is happy with out request
 	if resp.StatusCode != http.StatusOK {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: got bad status %d", resp.StatusCode)
+		return fmt.Errorf("%s: %w", op, statusError(resp))
 	}
-	body, err := ioutilReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: reading response error %w", err)
+		return fmt.Errorf("%s: reading response error %w", op, err)
 	}
 
-	a := Data{}
-	if err := jsonUnmarshal(body, &a); err != nil {
-		return struct{ Temperature, WindSpeed float64 }{}, fmt.Errorf("getWeather: unmarshalling response error %w", err)
+	// failures arrive with 200 OK too
+	e := envelope{}
+	if err := json.Unmarshal(body, &e); err != nil {
+		return fmt.Errorf("%s: unmarshalling response error %w", op, err)
+	}
+	if err := e.err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	return struct {
-		Temperature, WindSpeed float64
-	}{
-		Temperature: float64(a.Current.Temperature),
-		WindSpeed:   float64(a.Current.WindSpeed),
-	}, nil
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s: unmarshalling response error %w", op, err)
+	}
+	return nil
 }
 
-// Simple datastore for city name conversion
-// this could easily be an external cache with the orchestrators knowledge of
-// countries/provinces or states/cities as keys and this providers city list as
-// values
-var cities = map[string]string{"melbourne": "Melbourne", "sydney": "Sydney"}
-
-func (ws *WeatherStack) getCity(city string) (string, bool) {
-	c, ok := cities[strings.ToLower(strings.TrimSpace(city))]
-	return c, ok
+// getCity -
+// Weatherstack takes a single query for every kind of location.
+// An alias set for this provider in the registry is used first, then postcode,
+// then coordinates as "lat,lon", and finally "name, country".
+func (ws *WeatherStack) getCity(loc weather.Location) (string, bool) {
+	if alias, ok := loc.Alias(Name); ok {
+		return alias, true
+	}
+	if loc.Postcode != "" {
+		return loc.Postcode, true
+	}
+	if loc.HasCoordinates() {
+		return strconv.FormatFloat(loc.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(loc.Lon, 'f', -1, 64), true
+	}
+	if loc.Name == "" {
+		return "", false
+	}
+	if loc.Country == "" {
+		return loc.Name, true
+	}
+	return loc.Name + ", " + loc.Country, true
 }
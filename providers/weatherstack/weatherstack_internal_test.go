@@ -1,76 +1,151 @@
 package weatherstack
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/shanehowearth/weather"
 	"github.com/stretchr/testify/assert"
 )
 
-type fakeIOReadCloser struct{}
+// stub -
+// A provider calling a local server that answers with handler.
+func stub(t *testing.T, handler http.HandlerFunc, opts ...Option) *WeatherStack {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	ws, err := NewWeatherStack("Test Access Key", append([]Option{WithBaseURL(server.URL)}, opts...)...)
+	assert.Nil(t, err)
+	return ws
+}
+
+// respond -
+// A handler answering with status and body.
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}
+}
 
-var readResponse []byte
+// roundTripFunc -
+// A transport for failures that a server cannot produce.
+type roundTripFunc func(*http.Request) (*http.Response, error)
 
-func (f *fakeIOReadCloser) Read(p []byte) (n int, err error) {
-	return len(readResponse), nil
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
 }
-func (f *fakeIOReadCloser) Close() error {
-	return nil
+
+func float(f float64) *float64 {
+	return &f
 }
 
 func TestGetWeather(t *testing.T) {
-	fakeIORC := &fakeIOReadCloser{}
+	melbourne := weather.Location{
+		ID:      "melbourne",
+		Name:    "Melbourne",
+		Country: "AU",
+		Aliases: map[string]string{Name: "Melbourne"},
+	}
 
 	testcases := map[string]struct {
-		city         string
-		getError     error
-		ioError      error
-		marshalError error
-		outError     error
-		expectedResp *http.Response
-		expected     struct{ Temperature, WindSpeed float64 }
-		readResponse []byte
+		loc       weather.Location
+		params    map[string]string // expected upstream query parameters for the location
+		transport http.RoundTripper // instead of the stub server
+		status    int
+		body      string
+		outError  string
+		expected  weather.Observation
 	}{
-		"no city": {
-			outError: fmt.Errorf("city is required"),
+		"no location": {
+			outError: "location is required, not supported by this provider",
+		},
+		"no alias": {
+			loc:      weather.Location{ID: "alice springs", Name: "Alice Springs", Country: "AU"},
+			params:   map[string]string{"query": "Alice Springs, AU"},
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":615,"type":"request_failed","info":"Your API request failed. Please try again or contact support."}}`,
+			outError: "getWeather: error 615 request_failed: Your API request failed. Please try again or contact support.",
 		},
-		"non-existant city": {
-			city:     "non-existant",
-			outError: fmt.Errorf("'non-existant' is an unknown city for this provider"),
+		"coordinates": {
+			loc:      weather.Location{ID: "coord:-37.8140,144.9633", Name: "-37.8140,144.9633", Lat: -37.814, Lon: 144.9633},
+			params:   map[string]string{"query": "-37.814,144.9633"},
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":615,"type":"request_failed","info":"Your API request failed. Please try again or contact support."}}`,
+			outError: "getWeather: error 615 request_failed: Your API request failed. Please try again or contact support.",
+		},
+		"reserved characters": {
+			loc:      weather.Location{ID: "x", Name: "Rock & Roll #1?access_key=other", Country: "US"},
+			params:   map[string]string{"query": "Rock & Roll #1?access_key=other, US", "access_key": "Test Access Key"},
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":615,"type":"request_failed","info":"Your API request failed. Please try again or contact support."}}`,
+			outError: "getWeather: error 615 request_failed: Your API request failed. Please try again or contact support.",
+		},
+		"postcode": {
+			loc:      weather.Location{ID: "postcode:3000,au", Name: "3000", Postcode: "3000", Country: "AU"},
+			params:   map[string]string{"query": "3000"},
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":615,"type":"request_failed","info":"Your API request failed. Please try again or contact support."}}`,
+			outError: "getWeather: error 615 request_failed: Your API request failed. Please try again or contact support.",
 		},
 		"http error": {
-			city:     "Melbourne",
-			getError: fmt.Errorf("fake response error"),
-			outError: fmt.Errorf("fake response error"),
+			loc: melbourne,
+			transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("fake response error")
+			}),
+			outError: `getWeather: http.Get error Get "https://api.weatherstack.com/current?access_key=REDACTED&query=Melbourne&units=m": fake response error`,
 		},
 		"io error": {
-			city:         "Melbourne",
-			ioError:      fmt.Errorf("fake io error"),
-			expectedResp: &http.Response{Body: fakeIORC, Status: "200 OK", StatusCode: http.StatusOK},
-			outError:     fmt.Errorf("getWeather: reading response error fake io error"),
+			loc: melbourne,
+			transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(iotest.ErrReader(fmt.Errorf("fake io error")))}, nil
+			}),
+			outError: "getWeather: reading response error fake io error",
 		},
 		"upstream error": {
-			city:         "Melbourne",
-			expectedResp: &http.Response{Body: fakeIORC, StatusCode: http.StatusBadRequest},
-			outError:     fmt.Errorf("getWeather: got bad status 400"),
+			loc:      melbourne,
+			status:   http.StatusBadRequest,
+			outError: "getWeather: got bad status 400",
 		},
 		"json error": {
-			city:         "Melbourne",
-			marshalError: fmt.Errorf("fake json error"),
-			expectedResp: &http.Response{Body: fakeIORC, Status: "200 OK", StatusCode: http.StatusOK},
-			outError:     fmt.Errorf("getWeather: unmarshalling response error fake response error"),
+			loc:      melbourne,
+			status:   http.StatusOK,
+			body:     `{`,
+			outError: "getWeather: unmarshalling response error unexpected end of JSON input",
 		},
 		"melbourne": {
-			city:         "Melbourne",
-			expectedResp: &http.Response{Body: fakeIORC, Status: "200 OK", StatusCode: http.StatusOK},
-			expected: struct{ Temperature, WindSpeed float64 }{
+			loc:    melbourne,
+			params: map[string]string{"query": "Melbourne"},
+			status: http.StatusOK,
+			expected: weather.Observation{
 				Temperature: float64(15),
 				WindSpeed:   float64(28),
+				Units: weather.Units{
+					Temperature: weather.Celsius,
+					WindSpeed:   weather.KilometresPerHour,
+				},
+				ObservedAt:    time.Unix(1636653540, 0).UTC(),
+				Provider:      Name,
+				Location:      "Melbourne",
+				FeelsLike:     float(14),
+				WindDirection: float(170),
+				Humidity:      float(55),
+				Pressure:      float(1004),
+				Visibility:    float(10),
+				CloudCover:    float(0),
+				Precipitation: float(0),
+				Condition:     &weather.Condition{Code: weather.ConditionClear, Text: "Sunny"},
 			},
-			readResponse: []byte(`{
+			body: `{
     "current": {
         "cloudcover": 0,
         "feelslike": 14,
@@ -110,37 +185,444 @@ func TestGetWeather(t *testing.T) {
         "type": "City",
         "unit": "m"
     }
-}`),
+}`,
 		},
 	}
 	for name, tc := range testcases {
 		t.Run(name, func(t *testing.T) {
 			// Set up
-			httpGet = func(url string) (resp *http.Response, err error) {
-				return tc.expectedResp, tc.getError
+			ws := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/current", r.URL.Path)
+				for k, v := range tc.params {
+					assert.Equal(t, v, r.URL.Query().Get(k))
+				}
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+			if tc.transport != nil {
+				var err error
+				ws, err = NewWeatherStack("Test Access Key", WithTransport(tc.transport))
+				assert.Nil(t, err)
 			}
 
-			ioutilReadAll = func(r io.Reader) ([]byte, error) {
-				return tc.readResponse, tc.ioError
+			// Test
+			output, err := ws.GetWeatherContext(context.Background(), tc.loc)
+
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+				return
 			}
-			jsonUnmarshal = func(data []byte, v interface{}) error {
-				if tc.marshalError == nil {
-					return json.Unmarshal(data, v)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestGetForecast(t *testing.T) {
+	melbourne := weather.Location{ID: "melbourne", Name: "Melbourne", Aliases: map[string]string{Name: "Melbourne"}}
+
+	testcases := map[string]struct {
+		loc      weather.Location
+		hours    int
+		params   map[string]string
+		status   int
+		body     string
+		outError string
+		expected weather.Forecast
+	}{
+		"no location": {
+			hours:    24,
+			outError: "location is required, not supported by this provider",
+		},
+		"upstream error": {
+			loc:      melbourne,
+			hours:    24,
+			status:   http.StatusInternalServerError,
+			outError: "getForecast: got bad status 500",
+		},
+		"bad offset": {
+			loc:      melbourne,
+			hours:    24,
+			status:   http.StatusOK,
+			body:     `{"location":{"name":"Melbourne"},"forecast":{}}`,
+			outError: `getForecast: bad utc_offset ""`,
+		},
+		"bad time": {
+			loc:      melbourne,
+			hours:    24,
+			status:   http.StatusOK,
+			body:     `{"location":{"utc_offset":"11.0"},"forecast":{"2021-11-12":{"hourly":[{"time":"noon"}]}}}`,
+			outError: `getForecast: bad time "noon" on "2021-11-12"`,
+		},
+		"melbourne": {
+			loc:    melbourne,
+			hours:  30,
+			params: map[string]string{"query": "Melbourne", "forecast_days": "2", "hourly": "1", "interval": "1"},
+			status: http.StatusOK,
+			body: `{"location":{"name":"Melbourne","utc_offset":"11.0"},"forecast":{
+				"2021-11-13":{"date":"2021-11-13","mintemp":9,"maxtemp":17,"hourly":[
+					{"time":"0","temperature":11,"wind_speed":9,"windgust":15}]},
+				"2021-11-12":{"date":"2021-11-12","mintemp":10,"maxtemp":18,"hourly":[
+					{"time":"900","temperature":14,"wind_speed":11,"windgust":19},
+					{"time":"2300","temperature":12,"wind_speed":7,"windgust":12}]}}}`,
+			expected: weather.Forecast{
+				Hourly: []weather.ForecastPeriod{
+					{Time: time.Date(2021, 11, 11, 22, 0, 0, 0, time.UTC), Temperature: 14, WindSpeed: 11, WindGust: 19},
+					{Time: time.Date(2021, 11, 12, 12, 0, 0, 0, time.UTC), Temperature: 12, WindSpeed: 7, WindGust: 12},
+					{Time: time.Date(2021, 11, 12, 13, 0, 0, 0, time.UTC), Temperature: 11, WindSpeed: 9, WindGust: 15},
+				},
+				Units:    weather.Units{Temperature: weather.Celsius, WindSpeed: weather.KilometresPerHour},
+				Provider: Name,
+				Location: "Melbourne",
+			},
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ws := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/forecast", r.URL.Path)
+				for k, v := range tc.params {
+					assert.Equal(t, v, r.URL.Query().Get(k))
 				}
-				return tc.marshalError
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			output, err := ws.GetForecastContext(context.Background(), tc.loc, tc.hours)
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+				return
 			}
-			ws, err := NewWeatherStack("Test Access Key")
 			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
 
-			// Test
-			output, err := ws.GetWeather(tc.city)
+func TestGetHistory(t *testing.T) {
+	melbourne := weather.Location{ID: "melbourne", Name: "Melbourne", Aliases: map[string]string{Name: "Melbourne"}}
+	aedt := 11 * 60 * 60
+	date := time.Date(2021, 11, 10, 0, 0, 0, 0, time.FixedZone("AEDT", aedt))
 
-			if tc.outError == nil {
-				assert.Nil(t, err)
-				assert.Equal(t, tc.expected, output)
-			} else {
-				assert.NotNil(t, err)
+	testcases := map[string]struct {
+		loc      weather.Location
+		status   int
+		body     string
+		outError string
+		expected weather.History
+	}{
+		"no location": {
+			outError: "location is required, not supported by this provider",
+		},
+		"bad time": {
+			loc:      melbourne,
+			status:   http.StatusOK,
+			body:     `{"location":{"utc_offset":"11.0"},"historical":{"2021-11-10":{"hourly":[{"time":"25:00"}]}}}`,
+			outError: `getHistory: bad time "25:00" on "2021-11-10"`,
+		},
+		"melbourne": {
+			loc:    melbourne,
+			status: http.StatusOK,
+			body: `{"location":{"name":"Melbourne","utc_offset":"11.0"},"historical":{
+				"2021-11-10":{"date":"2021-11-10","mintemp":10,"maxtemp":18,"hourly":[
+					{"time":"600","temperature":11,"wind_speed":9,"windgust":15},
+					{"time":"1200","temperature":16,"wind_speed":13,"windgust":24}]}}}`,
+			expected: weather.History{
+				Hourly: []weather.ForecastPeriod{
+					{Time: time.Date(2021, 11, 9, 19, 0, 0, 0, time.UTC), Temperature: 11, WindSpeed: 9, WindGust: 15},
+					{Time: time.Date(2021, 11, 10, 1, 0, 0, 0, time.UTC), Temperature: 16, WindSpeed: 13, WindGust: 24},
+				},
+				Units:     weather.Units{Temperature: weather.Celsius, WindSpeed: weather.KilometresPerHour},
+				Provider:  Name,
+				Location:  "Melbourne",
+				UTCOffset: &aedt,
+			},
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ws := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/historical", r.URL.Path)
+				assert.Equal(t, "2021-11-10", r.URL.Query().Get("historical_date"))
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			output, err := ws.GetHistoryContext(context.Background(), tc.loc, date)
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+				return
 			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, output)
 		})
 	}
 }
+
+func TestCondition(t *testing.T) {
+	testcases := map[int]weather.ConditionCode{
+		113: weather.ConditionClear,
+		116: weather.ConditionPartlyCloudy,
+		122: weather.ConditionOvercast,
+		248: weather.ConditionFog,
+		266: weather.ConditionDrizzle,
+		296: weather.ConditionRain,
+		308: weather.ConditionHeavyRain,
+		317: weather.ConditionSleet,
+		338: weather.ConditionSnow,
+		389: weather.ConditionThunderstorm,
+		999: weather.ConditionUnknown,
+	}
+	for code, expected := range testcases {
+		assert.Equal(t, expected, condition(code), "code %d", code)
+	}
+}
+
+// TestRecordedPayloads -
+// Responses recorded from Weatherstack, which reports measurements as
+// integers, decimals, or strings.
+func TestRecordedPayloads(t *testing.T) {
+	testcases := map[string]struct {
+		file     string
+		expected weather.Observation
+	}{
+		"integers": {
+			file: "current_integers.json",
+			expected: weather.Observation{
+				Temperature:   15,
+				WindSpeed:     28,
+				ObservedAt:    time.Unix(1636653540, 0).UTC(),
+				Location:      "Melbourne",
+				FeelsLike:     float(14),
+				WindDirection: float(170),
+				Humidity:      float(55),
+				Pressure:      float(1004),
+				Visibility:    float(10),
+				CloudCover:    float(0),
+				Precipitation: float(0),
+				Condition:     &weather.Condition{Code: weather.ConditionClear, Text: "Sunny"},
+			},
+		},
+		"decimals": {
+			file: "current_decimals.json",
+			expected: weather.Observation{
+				Temperature:   8.5,
+				WindSpeed:     13.7,
+				ObservedAt:    time.Unix(1636698600, 0).UTC(),
+				Location:      "Hobart",
+				FeelsLike:     float(5.9),
+				WindDirection: float(250),
+				Humidity:      float(87),
+				Pressure:      float(1012.4),
+				Visibility:    float(9.5),
+				CloudCover:    float(75),
+				Precipitation: float(0.3),
+				Condition:     &weather.Condition{Code: weather.ConditionRain, Text: "Light Rain"},
+			},
+		},
+		"strings": {
+			file: "current_strings.json",
+			expected: weather.Observation{
+				Temperature:   31,
+				WindSpeed:     24.1,
+				ObservedAt:    time.Unix(1636696800, 0).UTC(),
+				Location:      "Perth",
+				FeelsLike:     float(30.5),
+				WindDirection: float(200),
+				Humidity:      float(20),
+				Pressure:      float(1010),
+				Visibility:    float(10),
+				CloudCover:    float(25),
+				Precipitation: float(0),
+				Condition:     &weather.Condition{Code: weather.ConditionPartlyCloudy, Text: "Partly cloudy"},
+			},
+		},
+		"nulls": {
+			file: "current_nulls.json",
+			expected: weather.Observation{
+				Temperature: 34,
+				WindSpeed:   19,
+				ObservedAt:  time.Unix(1636695000, 0).UTC(),
+				Location:    "Darwin",
+				FeelsLike:   float(38),
+				Humidity:    float(48),
+			},
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			payload, err := ioutil.ReadFile(filepath.Join("testdata", tc.file))
+			assert.Nil(t, err)
+			ws := stub(t, respond(http.StatusOK, string(payload)))
+
+			output, err := ws.GetWeatherContext(context.Background(), weather.Location{ID: "test", Name: "Test"})
+			assert.Nil(t, err)
+			tc.expected.Units = weather.Units{Temperature: weather.Celsius, WindSpeed: weather.KilometresPerHour}
+			tc.expected.Provider = Name
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+
+	t.Run("forecast", func(t *testing.T) {
+		payload, err := ioutil.ReadFile(filepath.Join("testdata", "forecast_decimals.json"))
+		assert.Nil(t, err)
+		ws := stub(t, respond(http.StatusOK, string(payload)))
+
+		output, err := ws.GetForecastContext(context.Background(), weather.Location{ID: "test", Name: "Test"}, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, []weather.ForecastPeriod{
+			{Time: time.Date(2021, 11, 11, 22, 0, 0, 0, time.UTC), Temperature: 14.3, WindSpeed: 11.2, WindGust: 19.8},
+			{Time: time.Date(2021, 11, 11, 23, 0, 0, 0, time.UTC), Temperature: 15.1, WindSpeed: 12, WindGust: 21.4},
+		}, output.Hourly)
+	})
+}
+
+func TestAPIError(t *testing.T) {
+	testcases := map[string]struct {
+		status     int
+		retryAfter string
+		body       string
+		outError   string
+		kind       error
+		wait       time.Duration // from Retry-After, if set
+	}{
+		"invalid key": {
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":101,"type":"invalid_access_key","info":"You have not supplied a valid API Access Key. [Technical Support: support@apilayer.com]"}}`,
+			outError: "getWeather: error 101 invalid_access_key: You have not supplied a valid API Access Key. [Technical Support: support@apilayer.com]",
+			kind:     weather.ErrAuth,
+		},
+		"quota exceeded": {
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":104,"type":"usage_limit_reached","info":"Your monthly usage limit has been reached. Please upgrade your Subscription Plan."}}`,
+			outError: "getWeather: error 104 usage_limit_reached: Your monthly usage limit has been reached. Please upgrade your Subscription Plan.",
+			kind:     weather.ErrQuotaExceeded,
+		},
+		"not in plan": {
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":105,"type":"function_access_restricted","info":"Your current Subscription Plan does not support this API Function."}}`,
+			outError: "getWeather: error 105 function_access_restricted: Your current Subscription Plan does not support this API Function.",
+			kind:     weather.ErrNotSupported,
+		},
+		"https not in plan": {
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":105,"type":"https_access_restricted","info":"Access Restricted - Your current Subscription Plan does not support HTTPS Encryption."}}`,
+			outError: "getWeather: error 105 https_access_restricted: Access Restricted - Your current Subscription Plan does not support HTTPS Encryption.",
+			kind:     weather.ErrAuth,
+		},
+		"unknown location": {
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":615,"type":"request_failed","info":"Your API request failed. Please try again or contact support."}}`,
+			outError: "getWeather: error 615 request_failed: Your API request failed. Please try again or contact support.",
+			kind:     weather.ErrUnknownLocation,
+		},
+		"other code": {
+			status:   http.StatusOK,
+			body:     `{"success":false,"error":{"code":606,"type":"invalid_unit","info":"You have specified an invalid unit."}}`,
+			outError: "getWeather: error 606 invalid_unit: You have specified an invalid unit.",
+		},
+		"no details": {
+			status:   http.StatusOK,
+			body:     `{"success":false}`,
+			outError: "getWeather: got bad status 200",
+		},
+		"rate limited": {
+			status:     http.StatusTooManyRequests,
+			retryAfter: "120",
+			outError:   "getWeather: got bad status 429",
+			kind:       weather.ErrRateLimited,
+			wait:       2 * time.Minute,
+		},
+		"unavailable": {
+			status:   http.StatusServiceUnavailable,
+			outError: "getWeather: got bad status 503",
+			kind:     weather.ErrUnavailable,
+		},
+	}
+	kinds := []error{weather.ErrAuth, weather.ErrQuotaExceeded, weather.ErrRateLimited, weather.ErrUnknownLocation, weather.ErrUnavailable, weather.ErrNotSupported}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ws := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			_, err := ws.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+			assert.EqualError(t, err, tc.outError)
+
+			var apiErr *APIError
+			assert.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tc.status, apiErr.StatusCode)
+			for _, kind := range kinds {
+				assert.Equal(t, kind == tc.kind, errors.Is(err, kind), kind.Error())
+			}
+			wait, ok := weather.RetryAfter(err)
+			assert.Equal(t, tc.wait != 0, ok)
+			assert.Equal(t, tc.wait, wait)
+		})
+	}
+}
+
+func TestTransportErrorIsTemporary(t *testing.T) {
+	ws, err := NewWeatherStack("s3cret/access+key", WithTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, context.DeadlineExceeded
+	})))
+	assert.Nil(t, err)
+
+	_, err = ws.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	// the access key is never given away
+	assert.EqualError(t, err, `getWeather: http.Get error Get "https://api.weatherstack.com/current?access_key=REDACTED&query=Melbourne&units=m": context deadline exceeded`)
+	assert.True(t, errors.Is(err, weather.ErrTemporary))
+	// the cause is still there to be found
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, weather.IsPermanent(err))
+}
+
+func TestOptions(t *testing.T) {
+	// a user agent
+	ws := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "weather-test/1.0", r.Header.Get("User-Agent"))
+		fmt.Fprint(w, `{"location":{"name":"Melbourne"}}`)
+	}, WithUserAgent("weather-test/1.0"))
+	_, err := ws.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.Nil(t, err)
+
+	// a timeout
+	release := make(chan struct{})
+	ws = stub(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}, WithTimeout(10*time.Millisecond))
+	_, err = ws.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	close(release)
+	assert.True(t, errors.Is(err, weather.ErrTemporary))
+	var urlErr *url.Error
+	assert.True(t, errors.As(err, &urlErr))
+	assert.True(t, urlErr.Timeout())
+
+	// a client of our own, which the other options do not change
+	calls := 0
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`{}`))}, nil
+	})}
+	ws, err = NewWeatherStack("test access key", WithHTTPClient(client), WithTimeout(time.Second))
+	assert.Nil(t, err)
+	_, err = ws.GetWeatherContext(context.Background(), weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, time.Duration(0), client.Timeout)
+}
+
+func TestContextReachesUpstream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ws := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		// abandoned by the caller, the request is cancelled upstream too
+		cancel()
+		<-r.Context().Done()
+	})
+	_, err := ws.GetWeatherContext(ctx, weather.Location{ID: "melbourne", Name: "Melbourne"})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
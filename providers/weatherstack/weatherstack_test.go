@@ -3,6 +3,7 @@ package weatherstack_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/shanehowearth/weather/providers/weatherstack"
 	"github.com/stretchr/testify/assert"
@@ -12,23 +13,42 @@ func TestNewWeatherStack(t *testing.T) {
 
 	testcases := map[string]struct {
 		appID string
+		opts  []weatherstack.Option
 		err   error
 	}{
 		"successful creation": {
 			appID: "test api key",
 		},
+		"with options": {
+			appID: "test api key",
+			opts: []weatherstack.Option{
+				weatherstack.WithBaseURL("http://localhost:8081/"),
+				weatherstack.WithTimeout(5 * time.Second),
+				weatherstack.WithUserAgent("weather/1.0"),
+			},
+		},
 		"no appID": {
-			err: fmt.Errorf("appID is required"),
+			err: fmt.Errorf("accessKey is required"),
+		},
+		"relative base URL": {
+			appID: "test api key",
+			opts:  []weatherstack.Option{weatherstack.WithBaseURL("/data")},
+			err:   fmt.Errorf("base URL must be absolute, got \"/data\""),
+		},
+		"negative timeout": {
+			appID: "test api key",
+			opts:  []weatherstack.Option{weatherstack.WithTimeout(-time.Second)},
+			err:   fmt.Errorf("timeout cannot be negative"),
 		},
 	}
 	for name, tc := range testcases {
 		t.Run(name, func(t *testing.T) {
-			ws, err := weatherstack.NewWeatherStack(tc.appID)
+			ws, err := weatherstack.NewWeatherStack(tc.appID, tc.opts...)
 			if tc.err == nil {
 				assert.Nil(t, err)
 				assert.NotNil(t, ws)
 			} else {
-				assert.NotNil(t, err)
+				assert.EqualError(t, err, tc.err.Error())
 			}
 		})
 	}
@@ -0,0 +1,77 @@
+package weather
+
+import (
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Redacted -
+// Stands in for a credential in errors and logs.
+const Redacted = "REDACTED"
+
+// RedactURL -
+// A copy of u with the values of the query parameters named in params
+// replaced with Redacted, eg. an upstream API key.
+func RedactURL(u *url.URL, params ...string) *url.URL {
+	r := *u
+	q := r.Query()
+	for _, p := range params {
+		if _, ok := q[p]; ok {
+			q.Set(p, Redacted)
+		}
+	}
+	r.RawQuery = q.Encode()
+	return &r
+}
+
+// RedactError -
+// The errors from building and making a request, *url.Error, quote the URL
+// in full. This is err with that URL redacted by RedactURL, the error it
+// wraps is kept so that errors.Is still finds the cause. Other errors are
+// returned as they are.
+func RedactError(err error, params ...string) error {
+	ue, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+	redacted := Redacted
+	// a URL that cannot be parsed cannot be redacted piecemeal either
+	if u, perr := url.Parse(ue.URL); perr == nil {
+		redacted = RedactURL(u, params...).String()
+	}
+	return &url.Error{Op: ue.Op, URL: redacted, Err: ue.Err}
+}
+
+// RedactWriter -
+// Writes to w with every secret, as it is or escaped for a URL, replaced with
+// Redacted. Meant for log.SetOutput as a last line of defence, the log
+// package writes each message in a single call so a secret is never split
+// across writes.
+func RedactWriter(w io.Writer, secrets ...string) io.Writer {
+	pairs := []string{}
+	for _, s := range secrets {
+		// replacing the empty string would redact everything in between
+		if s == "" {
+			continue
+		}
+		pairs = append(pairs, s, Redacted)
+		if escaped := url.QueryEscape(s); escaped != s {
+			pairs = append(pairs, escaped, Redacted)
+		}
+	}
+	return &redactWriter{w: w, r: strings.NewReplacer(pairs...)}
+}
+
+type redactWriter struct {
+	w io.Writer
+	r *strings.Replacer
+}
+
+// Write -
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.r.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,59 @@
+package weather_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"testing"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactURL(t *testing.T) {
+	u, err := url.Parse("http://api.example.com/current?query=Melbourne&access_key=secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://api.example.com/current?access_key=REDACTED&query=Melbourne", weather.RedactURL(u, "access_key", "missing").String())
+	// the original is left alone
+	assert.Equal(t, "secret", u.Query().Get("access_key"))
+}
+
+func TestRedactError(t *testing.T) {
+	testcases := map[string]struct {
+		err      error
+		expected string
+		cause    error
+	}{
+		"request error": {
+			err:      &url.Error{Op: "Get", URL: "http://api.example.com/weather?appid=secret&q=Melbourne", Err: context.DeadlineExceeded},
+			expected: `Get "http://api.example.com/weather?appid=REDACTED&q=Melbourne": context deadline exceeded`,
+			cause:    context.DeadlineExceeded,
+		},
+		"unparseable url": {
+			err:      &url.Error{Op: "parse", URL: "http://[::1/weather?appid=secret", Err: errors.New("missing ']' in host")},
+			expected: `parse "REDACTED": missing ']' in host`,
+		},
+		"other error": {
+			err:      errors.New("bad status 401"),
+			expected: "bad status 401",
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			err := weather.RedactError(tc.err, "appid")
+			assert.EqualError(t, err, tc.expected)
+			if tc.cause != nil {
+				assert.True(t, errors.Is(err, tc.cause))
+			}
+		})
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := log.New(weather.RedactWriter(buf, "", "top secret"), "", 0)
+	logger.Printf("ERROR calling http://api.example.com/?key=top+secret with top secret")
+	assert.Equal(t, "ERROR calling http://api.example.com/?key=REDACTED with REDACTED\n", buf.String())
+}
@@ -0,0 +1,214 @@
+package weather
+
+import (
+	"bytes"
+	// embed the default locations
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Registry -
+// The locations the service supports. Providers resolve their own query for a
+// location from it, so adding a location only means adding it here.
+type Registry struct {
+	locations []Location
+	byID      map[LocationID]int
+	// more than one location can share a name, eg. Melbourne, AU and
+	// Melbourne, US
+	byName map[LocationID][]int
+}
+
+//go:embed locations.json
+var defaultLocations []byte
+
+// DefaultRegistry -
+// The locations bundled with the service.
+func DefaultRegistry() *Registry {
+	r, err := ParseRegistry(bytes.NewReader(defaultLocations), "json")
+	if err != nil {
+		// the bundled file is checked by the tests, so this cannot happen
+		panic(fmt.Sprintf("bundled locations are invalid: %v", err))
+	}
+	return r
+}
+
+// NewRegistry -
+// Build a registry from locations. A location without an ID is given one from
+// its name, and IDs must be unique.
+func NewRegistry(locations []Location) (*Registry, error) {
+	if len(locations) < 1 {
+		return nil, fmt.Errorf("must have at least one location")
+	}
+	r := &Registry{
+		byID:   map[LocationID]int{},
+		byName: map[LocationID][]int{},
+	}
+	for _, l := range locations {
+		if l.Name == "" {
+			return nil, fmt.Errorf("location %q has no name", l.ID)
+		}
+		// IDs are written by hand, so canonicalise them the same way as the
+		// names clients send
+		if l.ID == "" {
+			l.ID = NewLocationID(l.Name)
+		}
+		l.ID = NewLocationID(l.ID.String())
+		if _, ok := r.byID[l.ID]; ok {
+			return nil, fmt.Errorf("duplicate location id %q", l.ID)
+		}
+		l.Country = strings.ToUpper(strings.TrimSpace(l.Country))
+
+		r.byID[l.ID] = len(r.locations)
+		name := NewLocationID(l.Name)
+		r.byName[name] = append(r.byName[name], len(r.locations))
+		r.locations = append(r.locations, l)
+	}
+	return r, nil
+}
+
+// Lookup -
+// Find a location by its ID, or by its name when that is unambiguous.
+func (r *Registry) Lookup(name string) (Location, bool) {
+	id := NewLocationID(name)
+	if i, ok := r.byID[id]; ok {
+		return r.locations[i], true
+	}
+	if matches := r.byName[id]; len(matches) == 1 {
+		return r.locations[matches[0]], true
+	}
+	return Location{}, false
+}
+
+// LookupInCountry -
+// Find a location by its ID or name within country, for names that are used
+// in more than one country.
+func (r *Registry) LookupInCountry(name, country string) (Location, bool) {
+	id := NewLocationID(name)
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if i, ok := r.byID[id]; ok && r.locations[i].Country == country {
+		return r.locations[i], true
+	}
+	for _, i := range r.byName[id] {
+		if r.locations[i].Country == country {
+			return r.locations[i], true
+		}
+	}
+	return Location{}, false
+}
+
+// Locations -
+// Every location in the registry, in the order they were loaded.
+func (r *Registry) Locations() []Location {
+	return append([]Location{}, r.locations...)
+}
+
+// LoadRegistry -
+// Read the locations from a JSON, YAML, or CSV file, the format is taken from
+// the file extension.
+func LoadRegistry(path string) (*Registry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loadRegistry: reading %s error %w", path, err)
+	}
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	r, err := ParseRegistry(bytes.NewReader(b), format)
+	if err != nil {
+		return nil, fmt.Errorf("loadRegistry: %s %w", path, err)
+	}
+	return r, nil
+}
+
+// ParseRegistry -
+// Read the locations in format, one of json, yaml (or yml), or csv.
+//
+// JSON and YAML files hold a list of locations with the same field names as
+// the JSON form of Location.
+// CSV files have a header row naming the columns, using the same names, and a
+// column named alias.<provider> for each provider alias, eg.
+//
+//	id,name,country,region,lat,lon,timezone,alias.openweathermap
+//	melbourne,Melbourne,AU,Victoria,-37.814,144.9633,Australia/Melbourne,"melbourne,AU"
+func ParseRegistry(r io.Reader, format string) (*Registry, error) {
+	var locations []Location
+	var err error
+	switch format {
+	case "json":
+		err = json.NewDecoder(r).Decode(&locations)
+	case "yaml", "yml":
+		err = yaml.NewDecoder(r).Decode(&locations)
+	case "csv":
+		locations, err = readCSVLocations(r)
+	default:
+		return nil, fmt.Errorf("unsupported location format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s locations error %w", format, err)
+	}
+	return NewRegistry(locations)
+}
+
+// Prefix of CSV columns holding provider aliases
+const csvAliasPrefix = "alias."
+
+func readCSVLocations(r io.Reader) ([]Location, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 1 {
+		return nil, fmt.Errorf("missing header row")
+	}
+	header := rows[0]
+	locations := []Location{}
+	for n, row := range rows[1:] {
+		l := Location{}
+		for i, col := range header {
+			col = strings.TrimSpace(col)
+			v := strings.TrimSpace(row[i])
+			switch {
+			case col == "id":
+				l.ID = LocationID(v)
+			case col == "name":
+				l.Name = v
+			case col == "country":
+				l.Country = v
+			case col == "region":
+				l.Region = v
+			case col == "lat", col == "lon":
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					// the header is row 1
+					return nil, fmt.Errorf("row %d: bad %s %q", n+2, col, v)
+				}
+				if col == "lat" {
+					l.Lat = f
+				} else {
+					l.Lon = f
+				}
+			case col == "timezone":
+				l.Timezone = v
+			case strings.HasPrefix(col, csvAliasPrefix):
+				if v == "" {
+					continue
+				}
+				if l.Aliases == nil {
+					l.Aliases = map[string]string{}
+				}
+				l.Aliases[strings.TrimPrefix(col, csvAliasPrefix)] = v
+			default:
+				return nil, fmt.Errorf("unknown column %q", col)
+			}
+		}
+		locations = append(locations, l)
+	}
+	return locations, nil
+}
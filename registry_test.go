@@ -0,0 +1,193 @@
+package weather_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shanehowearth/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+var brisbane = weather.Location{
+	ID:       "brisbane",
+	Name:     "Brisbane",
+	Country:  "AU",
+	Region:   "Queensland",
+	Lat:      -27.4698,
+	Lon:      153.0251,
+	Timezone: "Australia/Brisbane",
+	Aliases:  map[string]string{"openweathermap": "brisbane,AU"},
+}
+
+func TestParseRegistry(t *testing.T) {
+	testcases := map[string]struct {
+		format string
+		input  string
+		err    string
+	}{
+		"json": {
+			format: "json",
+			input: `[{"id":"brisbane","name":"Brisbane","country":"AU","region":"Queensland",
+				"lat":-27.4698,"lon":153.0251,"timezone":"Australia/Brisbane",
+				"aliases":{"openweathermap":"brisbane,AU"}}]`,
+		},
+		"yaml": {
+			format: "yaml",
+			input: `
+- id: brisbane
+  name: Brisbane
+  country: AU
+  region: Queensland
+  lat: -27.4698
+  lon: 153.0251
+  timezone: Australia/Brisbane
+  aliases:
+    openweathermap: brisbane,AU
+`,
+		},
+		"csv": {
+			format: "csv",
+			input: `id,name,country,region,lat,lon,timezone,alias.openweathermap,alias.weatherstack
+brisbane,Brisbane,AU,Queensland,-27.4698,153.0251,Australia/Brisbane,"brisbane,AU",
+`,
+		},
+		"csv id from name": {
+			format: "csv",
+			input: `name,country,region,lat,lon,timezone,alias.openweathermap
+Brisbane,au,Queensland,-27.4698,153.0251,Australia/Brisbane,"brisbane,AU"
+`,
+		},
+		"csv bad latitude": {
+			format: "csv",
+			input:  "name,lat\nBrisbane,north\n",
+			err:    `parsing csv locations error row 2: bad lat "north"`,
+		},
+		"csv unknown column": {
+			format: "csv",
+			input:  "name,population\nBrisbane,2500000\n",
+			err:    `parsing csv locations error unknown column "population"`,
+		},
+		"unsupported format": {
+			format: "xml",
+			err:    `unsupported location format "xml"`,
+		},
+		"no locations": {
+			format: "json",
+			input:  "[]",
+			err:    "must have at least one location",
+		},
+		"missing name": {
+			format: "json",
+			input:  `[{"id":"brisbane"}]`,
+			err:    `location "brisbane" has no name`,
+		},
+		"duplicate id": {
+			format: "json",
+			input:  `[{"name":"Brisbane"},{"id":" BRISBANE ","name":"Brisbane City"}]`,
+			err:    `duplicate location id "brisbane"`,
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			r, err := weather.ParseRegistry(strings.NewReader(tc.input), tc.format)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, []weather.Location{brisbane}, r.Locations())
+		})
+	}
+}
+
+func TestLoadRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "locations.YML")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("- name: Brisbane\n"), 0o600))
+	r, err := weather.LoadRegistry(path)
+	assert.Nil(t, err)
+	l, ok := r.Lookup("brisbane")
+	assert.True(t, ok)
+	assert.Equal(t, "Brisbane", l.Name)
+
+	_, err = weather.LoadRegistry(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r, err := weather.NewRegistry([]weather.Location{
+		{ID: "melbourne", Name: "Melbourne", Country: "AU"},
+		{ID: "melbourne-us", Name: "Melbourne", Country: "US"},
+		{ID: "alice-springs", Name: "Alice Springs", Country: "AU"},
+	})
+	assert.Nil(t, err)
+
+	testcases := map[string]struct {
+		name     string
+		expected weather.LocationID
+	}{
+		"by id":                {name: "melbourne-us", expected: "melbourne-us"},
+		"id wins over name":    {name: " Melbourne", expected: "melbourne"},
+		"unambiguous name":     {name: "ALICE  SPRINGS", expected: "alice-springs"},
+		"unknown":              {name: "Perth"},
+		"empty":                {name: ""},
+		"id is canonicalised":  {name: "Melbourne-US", expected: "melbourne-us"},
+		"name with formatting": {name: "Alice\u00a0Springs\u200b", expected: "alice-springs"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			l, ok := r.Lookup(tc.name)
+			assert.Equal(t, tc.expected != "", ok)
+			assert.Equal(t, tc.expected, l.ID)
+		})
+	}
+}
+
+func TestRegistryLookupInCountry(t *testing.T) {
+	r, err := weather.NewRegistry([]weather.Location{
+		{ID: "melbourne-au", Name: "Melbourne", Country: "AU"},
+		{ID: "melbourne-us", Name: "Melbourne", Country: "US"},
+	})
+	assert.Nil(t, err)
+
+	testcases := map[string]struct {
+		name, country string
+		expected      weather.LocationID
+	}{
+		"australia":          {name: "Melbourne", country: "au", expected: "melbourne-au"},
+		"united states":      {name: "melbourne", country: "US ", expected: "melbourne-us"},
+		"id in country":      {name: "melbourne-us", country: "US", expected: "melbourne-us"},
+		"id in other":        {name: "melbourne-us", country: "AU"},
+		"not in the country": {name: "Melbourne", country: "GB"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			l, ok := r.LookupInCountry(tc.name, tc.country)
+			assert.Equal(t, tc.expected != "", ok)
+			assert.Equal(t, tc.expected, l.ID)
+		})
+	}
+
+	// the name alone is ambiguous
+	_, ok := r.Lookup("Melbourne")
+	assert.False(t, ok)
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	r := weather.DefaultRegistry()
+	for _, city := range []string{"melbourne", "sydney"} {
+		l, ok := r.Lookup(city)
+		assert.True(t, ok)
+		assert.Equal(t, "AU", l.Country)
+		for _, provider := range []string{"openweathermap", "weatherstack"} {
+			_, ok := l.Alias(provider)
+			assert.True(t, ok, "%s has no %s alias", city, provider)
+		}
+	}
+}
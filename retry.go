@@ -0,0 +1,229 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy -
+// How failed provider calls are retried by WithRetry.
+type RetryPolicy struct {
+	// Attempts is the most calls made for each request, including the first
+	Attempts int
+	// BaseDelay is the longest wait before the first retry, it doubles for
+	// each retry after that. The wait is chosen at random up to this limit,
+	// so that many requests failing together do not retry together.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts. A provider asking, with
+	// Retry-After, to be left alone for longer is not retried.
+	MaxDelay time.Duration
+	// BudgetRatio is the number of retries earned by each request, eg. 0.1
+	// allows one retry for every ten requests, so that retries cannot
+	// multiply the load on a provider that is struggling
+	BudgetRatio float64
+	// BudgetBurst is the number of retries allowed before any are earned, and
+	// the most that can be saved up, which is always at least one
+	BudgetBurst int
+}
+
+// DefaultRetryPolicy -
+// Three attempts, waiting up to 100ms then 200ms, with retries limited to a
+// fifth of requests.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:    3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+	BudgetRatio: 0.2,
+	BudgetBurst: 10,
+}
+
+// validate -
+func (p RetryPolicy) validate() error {
+	switch {
+	case p.Attempts < 1:
+		return fmt.Errorf("retry attempts must be at least 1")
+	case p.BaseDelay < 0 || p.MaxDelay < 0:
+		return fmt.Errorf("retry delays cannot be negative")
+	case p.BudgetRatio < 0 || p.BudgetBurst < 0:
+		return fmt.Errorf("retry budget cannot be negative")
+	}
+	return nil
+}
+
+// delay -
+// The wait before retry number n, counting from zero, chosen at random up to
+// the exponential limit ("full jitter").
+func (p RetryPolicy) delay(n int) time.Duration {
+	limit := p.BaseDelay
+	for i := 0; i < n && limit < p.MaxDelay; i++ {
+		limit *= 2
+	}
+	if limit > p.MaxDelay {
+		limit = p.MaxDelay
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(randInt63n(int64(limit) + 1))
+}
+
+// Allow the jitter to be faked in tests
+var randInt63n = rand.Int63n
+
+// WithRetry -
+// Retry calls to p that fail, unless the error is permanent (see
+// IsPermanent), the caller's context is done, or there is not time to wait
+// before its deadline. A wait asked for with Retry-After is honoured. The
+// error from the last attempt is returned.
+func WithRetry(p Provider, policy RetryPolicy) (Provider, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	budget := &retryBudget{ratio: policy.BudgetRatio, max: float64(policy.BudgetBurst), tokens: float64(policy.BudgetBurst)}
+	// a retry has to be earned before it can be made
+	if budget.max < 1 {
+		budget.max = 1
+	}
+	return &retryProvider{p: p, policy: policy, budget: budget}, nil
+}
+
+type retryProvider struct {
+	p      Provider
+	policy RetryPolicy
+	budget *retryBudget
+}
+
+// Name -
+func (r *retryProvider) Name() string {
+	return providerName(r.p)
+}
+
+// GetWeatherContext -
+func (r *retryProvider) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
+	var val Observation
+	err := r.do(ctx, func() (err error) {
+		val, err = r.p.GetWeatherContext(ctx, loc)
+		return err
+	})
+	return val, err
+}
+
+// GetForecastContext -
+// Providers that cannot forecast report ErrNotSupported.
+func (r *retryProvider) GetForecastContext(ctx context.Context, loc Location, hours int) (Forecast, error) {
+	f, ok := r.p.(Forecaster)
+	if !ok {
+		return Forecast{}, ErrNotSupported
+	}
+	var val Forecast
+	err := r.do(ctx, func() (err error) {
+		val, err = f.GetForecastContext(ctx, loc, hours)
+		return err
+	})
+	return val, err
+}
+
+// GetHistoryContext -
+// Providers without history report ErrNotSupported.
+func (r *retryProvider) GetHistoryContext(ctx context.Context, loc Location, date time.Time) (History, error) {
+	h, ok := r.p.(HistoryProvider)
+	if !ok {
+		return History{}, ErrNotSupported
+	}
+	var val History
+	err := r.do(ctx, func() (err error) {
+		val, err = h.GetHistoryContext(ctx, loc, date)
+		return err
+	})
+	return val, err
+}
+
+// do -
+// Call until it succeeds or it is time to give up.
+func (r *retryProvider) do(ctx context.Context, call func() error) error {
+	r.budget.earn()
+	var err error
+	for n := 0; n < r.policy.Attempts; n++ {
+		if n > 0 {
+			wait, ok := r.wait(ctx, n-1, err)
+			if !ok || !r.budget.spend() {
+				return err
+			}
+			if !sleep(ctx, wait) {
+				return err
+			}
+		}
+		if err = call(); err == nil || IsPermanent(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// wait -
+// How long to wait before retry n after err, or false when there is no point
+// retrying.
+func (r *retryProvider) wait(ctx context.Context, n int, err error) (time.Duration, bool) {
+	// the caller has gone away, or run out of time
+	if ctx.Err() != nil {
+		return 0, false
+	}
+	wait := r.policy.delay(n)
+	if after, ok := RetryAfter(err); ok {
+		if after > r.policy.MaxDelay {
+			return 0, false
+		}
+		wait = after
+	}
+	if deadline, ok := ctx.Deadline(); ok && wait >= time.Until(deadline) {
+		return 0, false
+	}
+	return wait, true
+}
+
+// sleep -
+// Wait for d, or until ctx is done, reporting whether the wait finished.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryBudget -
+// A token bucket, each request adds ratio tokens, and each retry takes one.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+func (b *retryBudget) earn() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *retryBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,213 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRetry(t *testing.T) {
+	blip := MarkTemporary(fmt.Errorf("connection reset"))
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond, BudgetBurst: 10}
+	testcases := map[string]struct {
+		errs     []error
+		policy   *RetryPolicy // defaults to policy
+		timeout  time.Duration
+		calls    int
+		err      error
+		minTaken time.Duration
+	}{
+		"first call succeeds": {
+			errs:  []error{nil},
+			calls: 1,
+		},
+		"succeeds after blips": {
+			errs:  []error{blip, blip, nil},
+			calls: 3,
+		},
+		"runs out of attempts": {
+			errs:  []error{blip, ErrUnavailable, fmt.Errorf("fake error")},
+			calls: 3,
+			err:   fmt.Errorf("fake error"),
+		},
+		"permanent error": {
+			errs:  []error{fmt.Errorf("fake %w", ErrAuth)},
+			calls: 1,
+			err:   fmt.Errorf("fake %w", ErrAuth),
+		},
+		"permanent after a blip": {
+			errs:  []error{blip, ErrUnknownLocation},
+			calls: 2,
+			err:   ErrUnknownLocation,
+		},
+		"retry after is honoured": {
+			errs:     []error{&retryAfterError{error: ErrRateLimited, wait: 20 * time.Millisecond}, nil},
+			calls:    2,
+			minTaken: 20 * time.Millisecond,
+		},
+		"retry after is too long": {
+			errs:  []error{&retryAfterError{error: ErrRateLimited, wait: time.Minute}},
+			calls: 1,
+			err:   &retryAfterError{error: ErrRateLimited, wait: time.Minute},
+		},
+		"no time before the deadline": {
+			errs:    []error{&retryAfterError{error: ErrRateLimited, wait: 40 * time.Millisecond}},
+			timeout: 20 * time.Millisecond,
+			calls:   1,
+			err:     &retryAfterError{error: ErrRateLimited, wait: 40 * time.Millisecond},
+		},
+		"one attempt": {
+			errs:   []error{blip},
+			policy: &RetryPolicy{Attempts: 1},
+			calls:  1,
+			err:    blip,
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			p := &scriptedProvider{errs: tc.errs}
+			rp := policy
+			if tc.policy != nil {
+				rp = *tc.policy
+			}
+			r, err := WithRetry(p, rp)
+			assert.Nil(t, err)
+
+			ctx := context.Background()
+			if tc.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			_, err = r.GetWeatherContext(ctx, Location{})
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.calls, p.calls)
+			assert.GreaterOrEqual(t, int64(time.Since(start)), int64(tc.minTaken))
+		})
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	defer func() { randInt63n = rand.Int63n }()
+	// always wait as long as possible
+	randInt63n = func(n int64) int64 { return n - 1 }
+
+	p := &scriptedProvider{errs: []error{ErrUnavailable, nil}}
+	r, err := WithRetry(p, RetryPolicy{Attempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour, BudgetBurst: 1})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = r.GetWeatherContext(ctx, Location{})
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Equal(t, 1, p.calls)
+}
+
+func TestRetryWithinTimeout(t *testing.T) {
+	wait := &retryAfterError{error: ErrRateLimited, wait: 400 * time.Millisecond}
+	p := &scriptedProvider{errs: []error{wait, nil}}
+	r, err := WithRetry(p, RetryPolicy{Attempts: 2, MaxDelay: time.Second, BudgetBurst: 1})
+	assert.Nil(t, err)
+
+	// the caller sets no deadline, the timeout around the retries does, and
+	// as the wait would outlast it the retry is given up straight away
+	// rather than waited for until the timeout
+	start := time.Now()
+	_, err = WithTimeout(r, 200*time.Millisecond).GetWeatherContext(context.Background(), Location{})
+	assert.Equal(t, wait, err)
+	assert.Equal(t, 1, p.calls)
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestRetryBudget(t *testing.T) {
+	p := &scriptedProvider{errs: []error{ErrUnavailable, nil, ErrUnavailable, ErrUnavailable, nil, ErrUnavailable, nil}}
+	r, err := WithRetry(p, RetryPolicy{Attempts: 2, BudgetRatio: 0.5, BudgetBurst: 1})
+	assert.Nil(t, err)
+
+	// the one retry in the budget
+	_, err = r.GetWeatherContext(context.Background(), Location{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, p.calls)
+
+	// half a retry has been earned since, which is not enough
+	_, err = r.GetWeatherContext(context.Background(), Location{})
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Equal(t, 3, p.calls)
+
+	// and now a whole one
+	_, err = r.GetWeatherContext(context.Background(), Location{})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, p.calls)
+
+	// spent again
+	_, err = r.GetWeatherContext(context.Background(), Location{})
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Equal(t, 6, p.calls)
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer func() { randInt63n = rand.Int63n }()
+	// the longest possible wait
+	randInt63n = func(n int64) int64 { return n - 1 }
+
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n, expected := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		assert.Equal(t, expected, p.delay(n), n)
+	}
+	assert.Equal(t, time.Second, p.delay(1000))
+
+	// the shortest possible wait
+	randInt63n = func(n int64) int64 { return 0 }
+	assert.Equal(t, time.Duration(0), p.delay(3))
+}
+
+func TestRetryNotSupported(t *testing.T) {
+	r, err := WithRetry(&scriptedProvider{}, DefaultRetryPolicy)
+	assert.Nil(t, err)
+	_, err = r.(Forecaster).GetForecastContext(context.Background(), Location{}, 24)
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = r.(HistoryProvider).GetHistoryContext(context.Background(), Location{}, time.Now())
+	assert.Equal(t, ErrNotSupported, err)
+
+	// forecasts are retried as well
+	f := &fakeForecaster{err: ErrUnavailable}
+	r, err = WithRetry(f, RetryPolicy{Attempts: 3, BudgetBurst: 10})
+	assert.Nil(t, err)
+	_, err = r.(Forecaster).GetForecastContext(context.Background(), Location{}, 24)
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Equal(t, 3, f.calls)
+}
+
+func TestRetryPolicyValidation(t *testing.T) {
+	testcases := map[string]struct {
+		policy RetryPolicy
+		err    string
+	}{
+		"default":         {policy: DefaultRetryPolicy},
+		"no attempts":     {policy: RetryPolicy{}, err: "retry attempts must be at least 1"},
+		"negative delay":  {policy: RetryPolicy{Attempts: 2, BaseDelay: -time.Second}, err: "retry delays cannot be negative"},
+		"negative budget": {policy: RetryPolicy{Attempts: 2, BudgetRatio: -1}, err: "retry budget cannot be negative"},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			_, err := WithRetry(&scriptedProvider{}, tc.policy)
+			if tc.err == "" {
+				assert.Nil(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/weather/units"
+)
+
+// Strategy -
+// Decides how the providers are queried for a single lookup.
+// Implementations return an *AllFailedError when no provider succeeded.
+type Strategy interface {
+	Fetch(ctx context.Context, providers []Provider, loc Location) (Observation, error)
+}
+
+// StrategyFunc -
+// Allow an ordinary function to be used as a Strategy.
+type StrategyFunc func(ctx context.Context, providers []Provider, loc Location) (Observation, error)
+
+// Fetch -
+func (f StrategyFunc) Fetch(ctx context.Context, providers []Provider, loc Location) (Observation, error) {
+	return f(ctx, providers, loc)
+}
+
+// Sequential -
+// Try each provider in order, stopping at the first success. A slow provider
+// delays every provider after it. This is the default strategy.
+func Sequential() Strategy {
+	return StrategyFunc(sequential)
+}
+
+func sequential(ctx context.Context, providers []Provider, loc Location) (Observation, error) {
+	failed := &AllFailedError{}
+	for i := range providers {
+		// the client has gone away, or the server is shutting down
+		if ctx.Err() != nil {
+			break
+		}
+		val, err := observe(ctx, providers[i], loc)
+		if err != nil {
+			failed.Failures = append(failed.Failures, failure(providers[i], err))
+			continue
+		}
+		return val, nil
+	}
+	return Observation{}, failed
+}
+
+// Hedged -
+// Start the providers in order, starting the next one each time delay passes
+// without an answer, or as soon as a running provider fails. The first
+// success wins and the remaining calls are cancelled.
+// A delay of zero starts every provider at once.
+func Hedged(delay time.Duration) Strategy {
+	return &hedged{delay: delay}
+}
+
+// FirstSuccess -
+// Query every provider at once and use whichever succeeds first, so latency is
+// bounded by the fastest healthy provider.
+func FirstSuccess() Strategy {
+	return Hedged(0)
+}
+
+type hedged struct {
+	delay time.Duration
+}
+
+// result of a single provider call made by a concurrent strategy
+type result struct {
+	index int
+	val   Observation
+	err   error
+}
+
+// Fetch -
+func (h *hedged) Fetch(ctx context.Context, providers []Provider, loc Location) (Observation, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered so that abandoned calls can always deliver their result
+	results := make(chan result, len(providers))
+	launched, running := 0, 0
+	launch := func() {
+		go call(ctx, providers, launched, loc, results)
+		launched++
+		running++
+	}
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	answers := make([]*result, len(providers))
+	launch()
+	for running > 0 {
+		// with no delay there is nothing to wait for
+		if h.delay == 0 && launched < len(providers) {
+			launch()
+			continue
+		}
+
+		select {
+		case <-timer.C:
+			if launched < len(providers) {
+				launch()
+				timer.Reset(h.delay)
+			}
+		case res := <-results:
+			running--
+			if res.err == nil {
+				return res.val, nil
+			}
+			answers[res.index] = &res
+			// a failure starts the next provider straight away, whether or
+			// not others are still running
+			if launched < len(providers) {
+				launch()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(h.delay)
+			}
+		case <-ctx.Done():
+			return Observation{}, collectFailures(providers, answers)
+		}
+	}
+	return Observation{}, collectFailures(providers, answers)
+}
+
+// Aggregator -
+// Combine the observations from several providers into one.
+type Aggregator func(obs []Observation) (Observation, error)
+
+// All -
+// Query every provider at once, wait for all of them to answer, and combine the
+// successful answers with agg.
+func All(agg Aggregator) Strategy {
+	return &all{agg: agg}
+}
+
+type all struct {
+	agg Aggregator
+}
+
+// Fetch -
+func (a *all) Fetch(ctx context.Context, providers []Provider, loc Location) (Observation, error) {
+	results := make(chan result, len(providers))
+	for i := range providers {
+		go call(ctx, providers, i, loc, results)
+	}
+
+	// answers are kept in provider order so aggregation is deterministic
+	answers := make([]*result, len(providers))
+	for range providers {
+		select {
+		case res := <-results:
+			answers[res.index] = &res
+		case <-ctx.Done():
+			return Observation{}, collectFailures(providers, answers)
+		}
+	}
+
+	obs := []Observation{}
+	for i := range answers {
+		if answers[i].err == nil {
+			obs = append(obs, answers[i].val)
+		}
+	}
+	if len(obs) == 0 {
+		return Observation{}, collectFailures(providers, answers)
+	}
+	val, err := a.agg(obs)
+	if err != nil {
+		// report why the answers could not be combined alongside any failures
+		failed := collectFailures(providers, answers)
+		failed.Failures = append(failed.Failures, &ProviderError{Provider: "aggregate", Err: err})
+		return Observation{}, failed
+	}
+	return val, nil
+}
+
+// Mean -
+// Aggregator that averages the temperature and wind speed of every
+// observation, and each optional measurement over the observations that
+// report it. Values in different units cannot be averaged, so only the
+// observations in the same units as the first one are used.
+func Mean(obs []Observation) (Observation, error) {
+	if len(obs) == 0 {
+		return Observation{}, fmt.Errorf("no observations to aggregate")
+	}
+	out := Observation{Units: obs[0].Units, Location: obs[0].Location}
+	used := []Observation{}
+	names := []string{}
+	for i := range obs {
+		if obs[i].Units != out.Units {
+			continue
+		}
+		out.Temperature += obs[i].Temperature
+		out.WindSpeed += obs[i].WindSpeed
+		if obs[i].ObservedAt.After(out.ObservedAt) {
+			out.ObservedAt = obs[i].ObservedAt
+		}
+		used = append(used, obs[i])
+		names = append(names, obs[i].Provider)
+	}
+	out.Temperature /= float64(len(names))
+	out.WindSpeed /= float64(len(names))
+	out.Provider = strings.Join(names, ",")
+	combineDetails(&out, used, func(v []float64, _ []int) (float64, error) {
+		var sum float64
+		for i := range v {
+			sum += v[i]
+		}
+		return sum / float64(len(v)), nil
+	})
+	return out, nil
+}
+
+// combineDetails -
+// Fill in the optional measurements of out from obs, which must all be in the
+// same units. Each is combined over just the observations that report it,
+// from holds their indexes in obs, and is left out when combine fails. The
+// wind direction is combined as a bearing, so that 350 and 10 degrees make 0
+// rather than 180, and the condition is the first one reported.
+func combineDetails(out *Observation, obs []Observation, combine func(v []float64, from []int) (float64, error)) {
+	field := func(get func(o Observation) *float64) *float64 {
+		v, from := []float64{}, []int{}
+		for i := range obs {
+			if p := get(obs[i]); p != nil {
+				v = append(v, *p)
+				from = append(from, i)
+			}
+		}
+		if len(v) == 0 {
+			return nil
+		}
+		c, err := combine(v, from)
+		if err != nil {
+			return nil
+		}
+		return &c
+	}
+	out.FeelsLike = field(func(o Observation) *float64 { return o.FeelsLike })
+	out.WindGust = field(func(o Observation) *float64 { return o.WindGust })
+	out.Humidity = field(func(o Observation) *float64 { return o.Humidity })
+	out.Pressure = field(func(o Observation) *float64 { return o.Pressure })
+	out.Visibility = field(func(o Observation) *float64 { return o.Visibility })
+	out.CloudCover = field(func(o Observation) *float64 { return o.CloudCover })
+	out.Precipitation = field(func(o Observation) *float64 { return o.Precipitation })
+
+	bearing := func(f func(float64) float64) func(o Observation) *float64 {
+		return func(o Observation) *float64 {
+			if o.WindDirection == nil {
+				return nil
+			}
+			v := f(*o.WindDirection * math.Pi / 180)
+			return &v
+		}
+	}
+	north, east := field(bearing(math.Cos)), field(bearing(math.Sin))
+	if north != nil && east != nil {
+		d := math.Mod(math.Atan2(*east, *north)*180/math.Pi+360, 360)
+		out.WindDirection = &d
+	}
+
+	for i := range obs {
+		if obs[i].Condition != nil {
+			c := *obs[i].Condition
+			out.Condition = &c
+			break
+		}
+	}
+}
+
+func call(ctx context.Context, providers []Provider, i int, loc Location, results chan<- result) {
+	val, err := observe(ctx, providers[i], loc)
+	// a call cancelled because another provider has already won, or the
+	// client has gone away, is not the provider failing
+	if err != nil && !(ctx.Err() != nil && errors.Is(err, context.Canceled)) {
+		failure(providers[i], err)
+	}
+	results <- result{index: i, val: val, err: err}
+}
+
+// observe -
+// Ask p for the weather at loc, converted to the canonical units so that
+// every provider's answer means the same thing.
+func observe(ctx context.Context, p Provider, loc Location) (Observation, error) {
+	val, err := p.GetWeatherContext(ctx, loc)
+	if err != nil {
+		return Observation{}, err
+	}
+	return val.In(units.Canonical)
+}
+
+// failure -
+// Log a provider failure as it happens, even when another provider goes on
+// to succeed.
+func failure(p Provider, err error) *ProviderError {
+	pe := &ProviderError{Provider: providerName(p), Err: err}
+	log.Printf("ERROR %v", pe)
+	return pe
+}
+
+// collectFailures -
+// Concurrent strategies receive answers as they arrive, report the failures in
+// provider order instead.
+func collectFailures(providers []Provider, answers []*result) *AllFailedError {
+	failed := &AllFailedError{}
+	for i := range answers {
+		if answers[i] != nil && answers[i].err != nil {
+			failed.Failures = append(failed.Failures, &ProviderError{Provider: providerName(providers[i]), Err: answers[i].err})
+		}
+	}
+	return failed
+}
@@ -0,0 +1,213 @@
+package weather_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/weather"
+	"github.com/shanehowearth/weather/units"
+	"github.com/stretchr/testify/assert"
+)
+
+// namedProvider answers after delay, unless the context is done first
+type namedProvider struct {
+	name  string
+	delay time.Duration
+	temp  float64
+	wind  float64
+	err   error
+	// units default to celsius and metres per second
+	units weather.Units
+}
+
+func (n *namedProvider) Name() string {
+	return n.name
+}
+
+func (n *namedProvider) GetWeatherContext(ctx context.Context, loc weather.Location) (weather.Observation, error) {
+	select {
+	case <-ctx.Done():
+		return weather.Observation{}, ctx.Err()
+	case <-time.After(n.delay):
+	}
+	if n.err != nil {
+		return weather.Observation{}, n.err
+	}
+	u := n.units
+	if u == (weather.Units{}) {
+		u = weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond}
+	}
+	return weather.Observation{
+		Temperature: n.temp,
+		WindSpeed:   n.wind,
+		Units:       u,
+		Provider:    n.name,
+		Location:    loc.Name,
+	}, nil
+}
+
+func TestStrategies(t *testing.T) {
+	slow := &namedProvider{name: "slow", delay: 200 * time.Millisecond, temp: 1, wind: 2}
+	fast := &namedProvider{name: "fast", delay: time.Millisecond, temp: 3, wind: 4}
+	broken := &namedProvider{name: "broken", err: fmt.Errorf("fake error")}
+
+	testcases := map[string]struct {
+		strategy  weather.Strategy
+		providers []weather.Provider
+		provider  string
+		temp      float64
+		wind      float64
+		failures  []string
+		maxTime   time.Duration
+	}{
+		"sequential waits for the first provider": {
+			strategy:  weather.Sequential(),
+			providers: []weather.Provider{slow, fast},
+			provider:  "slow",
+			temp:      1,
+			wind:      2,
+		},
+		"sequential fails over": {
+			strategy:  weather.Sequential(),
+			providers: []weather.Provider{broken, fast},
+			provider:  "fast",
+			temp:      3,
+			wind:      4,
+		},
+		"sequential all fail": {
+			strategy:  weather.Sequential(),
+			providers: []weather.Provider{broken, broken},
+			failures:  []string{"broken", "broken"},
+		},
+		"first success uses the fastest provider": {
+			strategy:  weather.FirstSuccess(),
+			providers: []weather.Provider{slow, fast},
+			provider:  "fast",
+			temp:      3,
+			wind:      4,
+			maxTime:   100 * time.Millisecond,
+		},
+		"hedged starts the next provider after the delay": {
+			strategy:  weather.Hedged(10 * time.Millisecond),
+			providers: []weather.Provider{slow, fast},
+			provider:  "fast",
+			temp:      3,
+			wind:      4,
+			maxTime:   100 * time.Millisecond,
+		},
+		"hedged starts the next provider on failure": {
+			strategy:  weather.Hedged(time.Hour),
+			providers: []weather.Provider{broken, fast},
+			provider:  "fast",
+			temp:      3,
+			wind:      4,
+			maxTime:   100 * time.Millisecond,
+		},
+		"hedged starts the next provider on failure while others run": {
+			strategy:  weather.Hedged(50 * time.Millisecond),
+			providers: []weather.Provider{slow, broken, fast},
+			provider:  "fast",
+			temp:      3,
+			wind:      4,
+			// without starting on failure fast would wait for a second delay
+			maxTime: 90 * time.Millisecond,
+		},
+		"hedged all fail": {
+			strategy:  weather.Hedged(time.Millisecond),
+			providers: []weather.Provider{broken, &namedProvider{name: "other", err: fmt.Errorf("fake error")}},
+			failures:  []string{"broken", "other"},
+		},
+		"all with mean": {
+			strategy:  weather.All(weather.Mean),
+			providers: []weather.Provider{slow, broken, fast},
+			provider:  "slow,fast",
+			temp:      2,
+			wind:      3,
+		},
+		"all converts to the same units": {
+			strategy: weather.All(weather.Mean),
+			providers: []weather.Provider{fast, &namedProvider{
+				name: "imperial", temp: 41, wind: 36,
+				units: weather.Units{Temperature: units.Fahrenheit, WindSpeed: weather.KilometresPerHour},
+			}},
+			provider: "fast,imperial",
+			temp:     4,
+			wind:     7,
+		},
+		"sequential fails on unknown units": {
+			strategy:  weather.Sequential(),
+			providers: []weather.Provider{&namedProvider{name: "odd", units: weather.Units{Temperature: "rankine"}}},
+			failures:  []string{"odd"},
+		},
+		"all fail": {
+			strategy:  weather.All(weather.Mean),
+			providers: []weather.Provider{broken},
+			failures:  []string{"broken"},
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			output, err := tc.strategy.Fetch(context.Background(), tc.providers, weather.Location{ID: "melbourne", Name: "Melbourne"})
+			if tc.maxTime != 0 {
+				assert.Less(t, int64(time.Since(start)), int64(tc.maxTime))
+			}
+			if tc.failures == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.provider, output.Provider)
+				assert.Equal(t, tc.temp, output.Temperature)
+				assert.Equal(t, tc.wind, output.WindSpeed)
+				return
+			}
+			failed, ok := err.(*weather.AllFailedError)
+			assert.True(t, ok)
+			names := []string{}
+			for _, f := range failed.Failures {
+				names = append(names, f.Provider)
+			}
+			assert.Equal(t, tc.failures, names)
+		})
+	}
+}
+
+func TestMeanSkipsOtherUnits(t *testing.T) {
+	output, err := weather.Mean([]weather.Observation{
+		{Temperature: 10, WindSpeed: 1, Units: weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond}, Provider: "a"},
+		{Temperature: 20, WindSpeed: 36, Units: weather.Units{Temperature: weather.Celsius, WindSpeed: weather.KilometresPerHour}, Provider: "b"},
+		{Temperature: 30, WindSpeed: 3, Units: weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond}, Provider: "c"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(20), output.Temperature)
+	assert.Equal(t, float64(2), output.WindSpeed)
+	assert.Equal(t, "a,c", output.Provider)
+}
+
+func TestMeanCombinesDetails(t *testing.T) {
+	metric := weather.Units{Temperature: weather.Celsius, WindSpeed: weather.MetresPerSecond}
+	f := func(v float64) *float64 { return &v }
+	output, err := weather.Mean([]weather.Observation{
+		{
+			Provider: "a", Temperature: 10, WindSpeed: 2, Units: metric,
+			Humidity: f(50), Pressure: f(1010), WindGust: f(6), WindDirection: f(350),
+			Condition: &weather.Condition{Code: weather.ConditionCloudy, Text: "broken clouds"},
+		},
+		{
+			Provider: "b", Temperature: 20, WindSpeed: 4, Units: metric,
+			Humidity: f(70), Pressure: f(1020), WindDirection: f(10), Visibility: f(10),
+			Condition: &weather.Condition{Code: weather.ConditionRain},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 60.0, *output.Humidity)
+	assert.Equal(t, 1015.0, *output.Pressure)
+	// only reported by one provider
+	assert.Equal(t, 6.0, *output.WindGust)
+	assert.Equal(t, 10.0, *output.Visibility)
+	assert.Nil(t, output.FeelsLike)
+	// a bearing either side of north averages to north
+	assert.InDelta(t, 0, math.Mod(*output.WindDirection+180, 360)-180, 1e-9)
+	assert.Equal(t, &weather.Condition{Code: weather.ConditionCloudy, Text: "broken clouds"}, output.Condition)
+}
@@ -0,0 +1,130 @@
+// Package units converts temperatures and speeds between the units that
+// providers report in and that clients ask for.
+package units
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Temperature -
+// A unit of temperature.
+type Temperature string
+
+// Temperature units
+const (
+	Celsius    Temperature = "celsius"
+	Fahrenheit Temperature = "fahrenheit"
+	Kelvin     Temperature = "kelvin"
+)
+
+// Speed -
+// A unit of speed.
+type Speed string
+
+// Speed units
+const (
+	MetresPerSecond   Speed = "m/s"
+	KilometresPerHour Speed = "km/h"
+	MilesPerHour      Speed = "mph"
+	Knots             Speed = "knots"
+)
+
+// System -
+// The units a set of measurements are given in.
+type System struct {
+	Temperature Temperature
+	Speed       Speed
+}
+
+// Systems clients can ask for
+var (
+	// Metric is degrees Celsius and metres per second, the same as
+	// OpenWeatherMap's metric units
+	Metric = System{Temperature: Celsius, Speed: MetresPerSecond}
+	// Imperial is degrees Fahrenheit and miles per hour
+	Imperial = System{Temperature: Fahrenheit, Speed: MilesPerHour}
+	// SI is kelvin and metres per second
+	SI = System{Temperature: Kelvin, Speed: MetresPerSecond}
+)
+
+// Canonical -
+// The units every measurement is held in between the providers and the
+// response. Speeds are SI, temperatures are in degrees Celsius, which is
+// also an SI unit, so that cached values read naturally.
+var Canonical = Metric
+
+// ParseSystem -
+// One of metric, imperial, or si.
+func ParseSystem(name string) (System, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "metric":
+		return Metric, nil
+	case "imperial":
+		return Imperial, nil
+	case "si":
+		return SI, nil
+	}
+	return System{}, fmt.Errorf("unknown units %q, must be one of metric, imperial, or si", name)
+}
+
+// ParseSpeed -
+// One of m/s, km/h, mph, or knots.
+func ParseSpeed(name string) (Speed, error) {
+	switch s := Speed(strings.ToLower(strings.TrimSpace(name))); s {
+	case MetresPerSecond, KilometresPerHour, MilesPerHour, Knots:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown speed units %q, must be one of m/s, km/h, mph, or knots", name)
+}
+
+// Metres per second in each speed unit
+var metresPerSecond = map[Speed]float64{
+	MetresPerSecond:   1,
+	KilometresPerHour: 1000.0 / 3600,
+	MilesPerHour:      1609.344 / 3600,
+	Knots:             1852.0 / 3600,
+}
+
+// ConvertSpeed -
+// v measured in from, in to.
+func ConvertSpeed(v float64, from, to Speed) (float64, error) {
+	f, ok := metresPerSecond[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown speed units %q", from)
+	}
+	t, ok := metresPerSecond[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown speed units %q", to)
+	}
+	if from == to {
+		return v, nil
+	}
+	return v * f / t, nil
+}
+
+// ConvertTemperature -
+// v measured in from, in to.
+func ConvertTemperature(v float64, from, to Temperature) (float64, error) {
+	// by way of celsius
+	var c float64
+	switch from {
+	case Celsius:
+		c = v
+	case Fahrenheit:
+		c = (v - 32) * 5 / 9
+	case Kelvin:
+		c = v - 273.15
+	default:
+		return 0, fmt.Errorf("unknown temperature units %q", from)
+	}
+	switch to {
+	case Celsius:
+		return c, nil
+	case Fahrenheit:
+		return c*9/5 + 32, nil
+	case Kelvin:
+		return c + 273.15, nil
+	}
+	return 0, fmt.Errorf("unknown temperature units %q", to)
+}
@@ -0,0 +1,78 @@
+package units_test
+
+import (
+	"testing"
+
+	"github.com/shanehowearth/weather/units"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertTemperature(t *testing.T) {
+	testcases := map[string]struct {
+		v        float64
+		from, to units.Temperature
+		expected float64
+		err      string
+	}{
+		"same":                  {v: 21.5, from: units.Celsius, to: units.Celsius, expected: 21.5},
+		"celsius to fahrenheit": {v: 100, from: units.Celsius, to: units.Fahrenheit, expected: 212},
+		"fahrenheit to celsius": {v: -40, from: units.Fahrenheit, to: units.Celsius, expected: -40},
+		"celsius to kelvin":     {v: 0, from: units.Celsius, to: units.Kelvin, expected: 273.15},
+		"kelvin to fahrenheit":  {v: 373.15, from: units.Kelvin, to: units.Fahrenheit, expected: 212},
+		"unknown from":          {from: "rankine", to: units.Celsius, err: `unknown temperature units "rankine"`},
+		"unknown to":            {from: units.Celsius, to: "", err: `unknown temperature units ""`},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			v, err := units.ConvertTemperature(tc.v, tc.from, tc.to)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.InDelta(t, tc.expected, v, 1e-9)
+		})
+	}
+}
+
+func TestConvertSpeed(t *testing.T) {
+	testcases := map[string]struct {
+		v        float64
+		from, to units.Speed
+		expected float64
+		err      string
+	}{
+		"same":         {v: 3, from: units.Knots, to: units.Knots, expected: 3},
+		"km/h to m/s":  {v: 36, from: units.KilometresPerHour, to: units.MetresPerSecond, expected: 10},
+		"m/s to km/h":  {v: 10, from: units.MetresPerSecond, to: units.KilometresPerHour, expected: 36},
+		"mph to km/h":  {v: 10, from: units.MilesPerHour, to: units.KilometresPerHour, expected: 16.09344},
+		"knots to m/s": {v: 1, from: units.Knots, to: units.MetresPerSecond, expected: 0.514444444},
+		"unknown from": {from: "furlongs/fortnight", to: units.Knots, err: `unknown speed units "furlongs/fortnight"`},
+		"unknown to":   {from: units.Knots, to: "mach", err: `unknown speed units "mach"`},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			v, err := units.ConvertSpeed(tc.v, tc.from, tc.to)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.InDelta(t, tc.expected, v, 1e-6)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	s, err := units.ParseSystem(" Imperial")
+	assert.Nil(t, err)
+	assert.Equal(t, units.Imperial, s)
+	_, err = units.ParseSystem("nautical")
+	assert.EqualError(t, err, `unknown units "nautical", must be one of metric, imperial, or si`)
+
+	speed, err := units.ParseSpeed("KM/H")
+	assert.Nil(t, err)
+	assert.Equal(t, units.KilometresPerHour, speed)
+	_, err = units.ParseSpeed("kph")
+	assert.EqualError(t, err, `unknown speed units "kph", must be one of m/s, km/h, mph, or knots`)
+}
@@ -1,54 +1,134 @@
 package weather
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/shanehowearth/weather/units"
 )
 
-// Minimum time between requests
-const minGap = 3 * time.Second
+type data struct {
+	providers []Provider
+	strategy  Strategy
+	flights   *group
+	cache     Cache
+	policy    CachePolicy
+	// forecasts change less often than observations
+	forecastPolicy CachePolicy
+	registry       *Registry
+	// most locations looked up at once by a batch request
+	batchWorkers int
+	gazetteer    *Gazetteer
+	// the registry, indexed for suggesting a supported city
+	known *Gazetteer
+}
 
-// Known cities
-var cities = map[string]struct{}{"melbourne": struct{}{}, "sydney": struct{}{}}
+// Option -
+// Configures the service created by New.
+type Option func(*data)
 
-// Provider -
-// All weather providers should implement this interface to allow them to be
-// used by the application.
-type Provider interface {
-	GetWeather(city string) (struct{ Temperature, WindSpeed float64 }, error)
+// WithStrategy -
+// Choose how the providers are queried, the default is Sequential.
+func WithStrategy(s Strategy) Option {
+	return func(d *data) {
+		d.strategy = s
+	}
 }
 
-type data struct {
-	m         sync.Mutex
-	providers []Provider
-	last      map[string]struct {
-		WindSpeed   float64 `json:"wind_speed"`
-		Temperature float64 `json:"temperature_degrees"`
+// WithCache -
+// Store observations in c instead of the default in memory LRU cache.
+func WithCache(c Cache) Option {
+	return func(d *data) {
+		d.cache = c
+	}
+}
+
+// WithCachePolicy -
+// Choose how long cached observations are used for, the default is
+// DefaultCachePolicy.
+func WithCachePolicy(p CachePolicy) Option {
+	return func(d *data) {
+		d.policy = p
+	}
+}
+
+// WithRegistry -
+// Serve the locations in r instead of the bundled DefaultRegistry.
+func WithRegistry(r *Registry) Option {
+	return func(d *data) {
+		d.registry = r
+	}
+}
+
+// WithGazetteer -
+// Search g for locations instead of the bundled DefaultGazetteer.
+func WithGazetteer(g *Gazetteer) Option {
+	return func(d *data) {
+		d.gazetteer = g
+	}
+}
+
+// WithBatchWorkers -
+// Look up at most n locations of a batch request at once, the default is
+// defaultBatchWorkers.
+func WithBatchWorkers(n int) Option {
+	return func(d *data) {
+		d.batchWorkers = n
 	}
-	touched map[string]time.Time
 }
 
 // NewData -
 // ignore linter warning on returning unexported type
 // nolint:revive
-func New(p []Provider) (*data, error) {
+func New(p []Provider, opts ...Option) (*data, error) {
 	// Must have at least one provider
 	if len(p) < 1 {
 		return nil, fmt.Errorf("must have at least one provider")
 	}
-	return &data{
-		providers: p,
-		touched:   map[string]time.Time{},
-		last: map[string]struct {
-			WindSpeed   float64 `json:"wind_speed"`
-			Temperature float64 `json:"temperature_degrees"`
-		}{},
-	}, nil
+	// providers that say they will not answer for a while are left alone
+	providers := make([]Provider, len(p))
+	for i := range p {
+		providers[i] = newBackoffProvider(p[i])
+	}
+	d := &data{
+		providers:      providers,
+		strategy:       Sequential(),
+		flights:        newGroup(),
+		policy:         DefaultCachePolicy,
+		forecastPolicy: DefaultForecastCachePolicy,
+		batchWorkers:   defaultBatchWorkers,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.strategy == nil {
+		return nil, fmt.Errorf("strategy cannot be nil")
+	}
+	if d.batchWorkers < 1 {
+		return nil, fmt.Errorf("batch workers must be at least 1")
+	}
+	if d.registry == nil {
+		d.registry = DefaultRegistry()
+	}
+	d.known = NewGazetteer(d.registry.Locations())
+	if d.gazetteer == nil {
+		d.gazetteer = DefaultGazetteer()
+	}
+	if d.cache == nil {
+		c, err := NewLRUCache(defaultCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		d.cache = c
+	}
+	return d, nil
 }
 
 // Enable the following to be faked in tests
@@ -62,68 +142,248 @@ func (d *data) Weather(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// city is required
-	query := r.URL.Query()
-	cityQuery, ok := query["city"]
-	if !ok {
-		http.Error(w, "Bad Request, unknown city", http.StatusBadRequest)
+	// several cities are a batch, eg. ?city=melbourne&city=sydney
+	if cities := r.URL.Query()["city"]; len(cities) > 1 {
+		country := r.URL.Query().Get("country")
+		items := make([]BatchItem, len(cities))
+		for i := range cities {
+			items[i] = BatchItem{City: cities[i], Country: country}
+		}
+		d.writeBatch(w, r, items)
 		return
 	}
-	city := cityQuery[0]
 
-	// unknown city provided
-	if _, ok := cities[strings.ToLower(strings.TrimSpace(city))]; !ok {
-		e := fmt.Sprintf("Sorry, don't know that city %q", city)
-		http.Error(w, e, http.StatusBadRequest)
+	// a city, coordinates, or postcode is required
+	loc, err := d.resolve(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// eg. ?fields=temperature_degrees,humidity
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sys, err := requestedUnits(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	d.m.Lock()
-	defer d.m.Unlock()
-	// Rate limit
-	// Note this limit is on this endpoint rather than specific provider
-	if timeNow().Sub(d.touched[city]) < minGap {
-		// use the cached value
-		if resp, err := json.Marshal(d.last[city]); err == nil {
-			_, err = w.Write(resp)
-			if err != nil {
-				log.Printf("unable to write cached %#v in GetWeather handler with error %v", d.last[city], err)
-			}
-		} else {
-			log.Printf("unable to marshal cached %#v, with error %v", d.last[city], err)
-		}
+	val, failed := d.lookup(r.Context(), loc)
+	if failed != nil {
+		writeFailure(w, failed, newErrorResponse(failed))
+		return
+	}
+	if val, err = val.In(sys); err != nil {
+		log.Printf("unable to convert %#v to %v, with error %v", val, sys, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if val.Stale {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	if fields == nil {
+		writeJSON(w, http.StatusOK, val)
+		return
+	}
+	selected, err := selectFields(val, fields)
+	if err != nil {
+		log.Printf("unable to select fields %v from %#v, with error %v", fields, val, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, http.StatusOK, selected)
+}
 
-	// try each of the providers
-	for i := range d.providers {
-		val, err := d.providers[i].GetWeather(city)
+// requestedUnits -
+// The units asked for with ?units=metric|imperial|si, metric by default, and
+// optionally a different unit for wind with ?wind_units=m/s|km/h|mph|knots.
+// The error is suitable for sending to the client.
+func requestedUnits(query url.Values) (units.System, error) {
+	sys := units.Metric
+	if name := query.Get("units"); name != "" {
+		s, err := units.ParseSystem(name)
+		if err != nil {
+			return units.System{}, fmt.Errorf("Bad Request, %v", err)
+		}
+		sys = s
+	}
+	if name := query.Get("wind_units"); name != "" {
+		speed, err := units.ParseSpeed(name)
 		if err != nil {
-			// log the error
-			log.Printf("ERROR %v", err)
-			continue
+			return units.System{}, fmt.Errorf("Bad Request, %v", err)
+		}
+		sys.Speed = speed
+	}
+	return sys, nil
+}
+
+// lookup -
+// The weather for loc, from the cache when the policy allows, otherwise from
+// the providers. When every provider fails the last known good value is
+// returned, marked as stale, if the policy allows.
+func (d *data) lookup(ctx context.Context, loc Location) (Observation, *AllFailedError) {
+	v, stale, err := d.cachedLookup(ctx, loc.ID.String(), d.policy, isObservation, d.fetcher(loc))
+	if err != nil {
+		failed, ok := err.(*AllFailedError)
+		if !ok {
+			log.Printf("ERROR weather lookup for %q: %v", loc.ID, err)
+			failed = &AllFailedError{}
 		}
+		return Observation{}, failed
+	}
+	val := v.(Observation)
+	val.Stale = stale
+	return val, nil
+}
 
-		// Update cache
-		d.touched[city] = timeNow()
-		d.last[city] = struct {
-			WindSpeed   float64 `json:"wind_speed"`
-			Temperature float64 `json:"temperature_degrees"`
-		}{
-			Temperature: val.Temperature,
-			WindSpeed:   val.WindSpeed,
+// cachedLookup -
+// The value for key from the cache when policy allows, otherwise from fetch.
+// Concurrent lookups for the same key share a single fetch. When fetch fails
+// the last known good value is returned, and reported as stale, if the policy
+// allows. Cached values that valid rejects are treated as missing.
+func (d *data) cachedLookup(ctx context.Context, key string, policy CachePolicy, valid func(interface{}) bool, fetch fetchFunc) (interface{}, bool, error) {
+	// Serve from the cache when the policy allows
+	// Note this limits calls for a location, WithLimit limits the calls made
+	// to each provider
+	entry, cached := d.cache.Get(key)
+	cached = cached && valid(entry.Value)
+	if cached {
+		switch policy.freshness(entry, timeNow()) {
+		case fresh:
+			return entry.Value, false, nil
+		case revalidate:
+			// refresh in the background, joining any lookup already running
+			go func() {
+				_, _ = d.flights.Do(context.Background(), key, fetch)
+			}()
+			return entry.Value, false, nil
 		}
+	}
 
-		// no need to try any more providers
-		break
+	// query the providers, concurrent requests for the same key share a
+	// single lookup
+	val, err := d.flights.Do(ctx, key, fetch)
+	if err == nil {
+		return val, false, nil
 	}
 
-	if resp, err := json.Marshal(d.last[city]); err == nil {
-		_, err = w.Write(resp)
+	// Every provider failed, fall back to the last known good value, but only
+	// when it is clearly marked as stale
+	if cached && policy.usableOnError(entry, timeNow()) {
+		return entry.Value, true, nil
+	}
+	return nil, false, err
+}
+
+// resolve -
+// Find the location a request is asking about, from one of
+//
+//	?city=name[&country=code]
+//	?lat=latitude&lon=longitude
+//	?postcode=code&country=code
+//
+// Every spelling of a city resolves to the same location, and so shares the
+// same cache entry and rate limit. Coordinates and postcodes do not need to be
+// in the registry. The error is suitable for sending to the client.
+func (d *data) resolve(query url.Values) (Location, error) {
+	country := query.Get("country")
+	switch {
+	case query.Get("lat") != "" || query.Get("lon") != "":
+		lat, latErr := strconv.ParseFloat(query.Get("lat"), 64)
+		lon, lonErr := strconv.ParseFloat(query.Get("lon"), 64)
+		if latErr != nil || lonErr != nil {
+			return Location{}, fmt.Errorf("Bad Request, lat and lon must both be numbers")
+		}
+		loc, err := NewCoordinateLocation(lat, lon)
 		if err != nil {
-			log.Printf("unable to write %#v in GetWeather handler with error %v", d.last[city], err)
+			return Location{}, fmt.Errorf("Bad Request, %v", err)
 		}
+		return loc, nil
+	case query.Get("postcode") != "":
+		loc, err := NewPostcodeLocation(query.Get("postcode"), country)
+		if err != nil {
+			return Location{}, fmt.Errorf("Bad Request, %v", err)
+		}
+		return loc, nil
+	}
+
+	cityQuery, ok := query["city"]
+	if !ok {
+		return Location{}, fmt.Errorf("Bad Request, unknown city")
+	}
+	city := cityQuery[0]
+	var loc Location
+	if country != "" {
+		loc, ok = d.registry.LookupInCountry(city, country)
 	} else {
-		log.Printf("unable to marshal %#v, with error %v", d.last[city], err)
+		loc, ok = d.registry.Lookup(city)
+	}
+	// unknown city provided
+	if !ok {
+		msg := fmt.Sprintf("Sorry, don't know that city %q", city)
+		if country != "" {
+			msg = fmt.Sprintf("Sorry, don't know that city %q in %q", city, country)
+		}
+		return Location{}, fmt.Errorf("%s%s", msg, d.suggest(city, country))
+	}
+	return loc, nil
+}
+
+// Most cities suggested for an unknown one
+const maxSuggestions = 3
+
+// suggest -
+// The supported cities closest to an unknown one, as ", did you mean ...?",
+// or nothing when none are close.
+func (d *data) suggest(city, country string) string {
+	matches := d.known.Search(city, country, "", defaultSearchDistance, maxSuggestions)
+	if len(matches) == 0 {
+		return ""
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = strconv.Quote(m.Location.Name)
+		// the name alone is ambiguous
+		if n, ok := d.registry.Lookup(m.Location.Name); !ok || n.ID != m.Location.ID {
+			names[i] = strconv.Quote(m.Location.Name + ", " + m.Location.Country)
+		}
+	}
+	return fmt.Sprintf(", did you mean %s?", strings.Join(names, " or "))
+}
+
+// fetcher -
+// Look up the weather for loc from the providers and cache the result.
+func (d *data) fetcher(loc Location) fetchFunc {
+	return func(ctx context.Context) (interface{}, error) {
+		val, err := d.strategy.Fetch(ctx, d.providers, loc)
+		if err != nil {
+			return nil, err
+		}
+		d.cache.Set(loc.ID.String(), Entry{Value: val, Stored: timeNow()})
+		return val, nil
+	}
+}
+
+func isObservation(v interface{}) bool {
+	_, ok := v.(Observation)
+	return ok
+}
+
+// writeJSON -
+// Marshal v and send it to the client with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("unable to marshal %#v, with error %v", v, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(resp); err != nil {
+		log.Printf("unable to write %#v with error %v", v, err)
 	}
 }
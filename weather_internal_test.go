@@ -1,6 +1,7 @@
 package weather
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,84 +9,272 @@ import (
 	"testing"
 	"time"
 
+	"github.com/shanehowearth/weather/units"
 	"github.com/stretchr/testify/assert"
 )
 
 type fakeProvider struct{}
 
-var fakeResponse struct{ Temperature, WindSpeed float64 }
+var fakeResponse Observation
 var fakeResponseErr error
 
-func (f *fakeProvider) GetWeather(city string) (struct{ Temperature, WindSpeed float64 }, error) {
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) GetWeatherContext(ctx context.Context, loc Location) (Observation, error) {
 	return fakeResponse, fakeResponseErr
 }
 
 func TestWeatherHandler(t *testing.T) {
 	base := "/v1/weather"
 	testcases := map[string]struct {
-		query  string
-		status int
-		body   struct {
-			Temperature float64 `json:"temperature_degrees"`
-			WindSpeed   float64 `json:"wind_speed"`
-		}
-		response struct{ Temperature, WindSpeed float64 }
+		query    string
+		status   int
+		body     Observation
+		response Observation
 		method   string // defaults to "GET"
 		errBody  string
 		myTime   time.Time // mandatory
 		fakeErr  error
+		cached   *Observation  // previously fetched value, if any
+		cacheAge time.Duration // how long before myTime the cached value was stored
+		policy   *CachePolicy  // defaults to DefaultCachePolicy
+		header   map[string]string
+		// revalidated is set when the cached value is expected to be refreshed
+		// in the background
+		revalidated bool
 	}{
 		"successful": {
 			query:  "?city=melbourne",
 			status: http.StatusOK,
-			body: struct {
-				Temperature float64 `json:"temperature_degrees"`
-				WindSpeed   float64 `json:"wind_speed"`
-			}{100, 150},
-			response: struct{ Temperature, WindSpeed float64 }{100, 150},
-			myTime:   time.Now(),
+			body: Observation{
+				Temperature: 100,
+				WindSpeed:   150,
+				Units:       Units{Temperature: Celsius, WindSpeed: MetresPerSecond},
+				ObservedAt:  time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC),
+				Provider:    "fake",
+				Location:    "Melbourne",
+			},
+			response: Observation{
+				Temperature: 100,
+				WindSpeed:   150,
+				Units:       Units{Temperature: Celsius, WindSpeed: MetresPerSecond},
+				ObservedAt:  time.Date(2021, 11, 11, 7, 0, 0, 0, time.UTC),
+				Provider:    "fake",
+				Location:    "Melbourne",
+			},
+			myTime: time.Now(),
+		},
+		"imperial units": {
+			query:  "?city=melbourne&units=imperial",
+			status: http.StatusOK,
+			body: Observation{
+				Temperature: 212,
+				WindSpeed:   22.369362920544024,
+				Units:       Units{Temperature: units.Fahrenheit, WindSpeed: units.MilesPerHour},
+			},
+			response: Observation{
+				Temperature: 100,
+				WindSpeed:   10,
+				Units:       Units{Temperature: Celsius, WindSpeed: MetresPerSecond},
+			},
+			myTime: time.Now(),
+		},
+		"wind units": {
+			query:  "?city=melbourne&units=si&wind_units=km/h",
+			status: http.StatusOK,
+			body: Observation{
+				Temperature: 373.15,
+				WindSpeed:   36,
+				Units:       Units{Temperature: units.Kelvin, WindSpeed: units.KilometresPerHour},
+			},
+			response: Observation{
+				Temperature: 100,
+				WindSpeed:   10,
+				Units:       Units{Temperature: Celsius, WindSpeed: MetresPerSecond},
+			},
+			myTime: time.Now(),
+		},
+		"unknown units": {
+			query:   "?city=melbourne&units=nautical",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, unknown units \"nautical\", must be one of metric, imperial, or si\n",
+		},
+		"unknown wind units": {
+			query:   "?city=melbourne&wind_units=kph",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, unknown speed units \"kph\", must be one of m/s, km/h, mph, or knots\n",
 		},
 		"wrong method": {
 			query:   "?city=melbourne",
 			status:  http.StatusMethodNotAllowed,
 			method:  "POST",
-			errBody: "Bad method - Go away!\n",
+			errBody: "Bad method\n",
 		},
 		"no city": {
 			status:  http.StatusBadRequest,
-			errBody: "Bad Request\n",
+			errBody: "Bad Request, unknown city\n",
 		},
 		"non-existant city": {
 			query:   "?city=fake",
 			status:  http.StatusBadRequest,
 			errBody: "Sorry, don't know that city \"fake\"\n",
 		},
+		"misspelt city": {
+			query:   "?city=Melborne",
+			status:  http.StatusBadRequest,
+			errBody: "Sorry, don't know that city \"Melborne\", did you mean \"Melbourne\"?\n",
+		},
+		"coordinates": {
+			query:    "?lat=-37.81&lon=144.96",
+			status:   http.StatusOK,
+			body:     Observation{Temperature: 100, WindSpeed: 150},
+			response: Observation{Temperature: 100, WindSpeed: 150},
+			myTime:   time.Now(),
+		},
+		"coordinates out of range": {
+			query:   "?lat=100&lon=144.96",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, latitude must be between -90 and 90\n",
+		},
+		"coordinates missing longitude": {
+			query:   "?lat=-37.81",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, lat and lon must both be numbers\n",
+		},
+		"postcode": {
+			query:    "?postcode=3000&country=au",
+			status:   http.StatusOK,
+			body:     Observation{Temperature: 100, WindSpeed: 150},
+			response: Observation{Temperature: 100, WindSpeed: 150},
+			myTime:   time.Now(),
+		},
+		"postcode without country": {
+			query:   "?postcode=3000",
+			status:  http.StatusBadRequest,
+			errBody: "Bad Request, country is required with a postcode\n",
+		},
+		"city in country": {
+			query:    "?city=Melbourne&country=au",
+			status:   http.StatusOK,
+			body:     Observation{Temperature: 100, WindSpeed: 150},
+			response: Observation{Temperature: 100, WindSpeed: 150},
+			myTime:   time.Now(),
+		},
+		"city in another country": {
+			query:   "?city=Melbourne&country=us",
+			status:  http.StatusBadRequest,
+			errBody: "Sorry, don't know that city \"Melbourne\" in \"us\"\n",
+		},
 		"too quick": {
 			query:  "?city=melbourne",
 			status: http.StatusOK,
-			body: struct {
-				Temperature float64 `json:"temperature_degrees"`
-				WindSpeed   float64 `json:"wind_speed"`
-			}{0, 0},
+			body:   Observation{},
 			myTime: time.Now().Add(-101 * 24 * 365 * time.Hour),
 		},
-		"failover": {
-			query:  "?city=melbourne",
-			status: http.StatusOK,
-			body: struct {
-				Temperature float64 `json:"temperature_degrees"`
-				WindSpeed   float64 `json:"wind_speed"`
-			}{0, 0},
-			response: struct{ Temperature, WindSpeed float64 }{100, 150},
+		"all providers failed": {
+			query:    "?city=melbourne",
+			status:   http.StatusBadGateway,
+			response: Observation{Temperature: 100, WindSpeed: 150},
+			myTime:   time.Now(),
+			fakeErr:  fmt.Errorf("fake error"),
+			errBody:  `{"error":"no provider was able to supply the weather","providers":[{"provider":"fake","error":"fake error"}]}`,
+		},
+		"all providers timed out": {
+			query:   "?city=melbourne",
+			status:  http.StatusServiceUnavailable,
+			myTime:  time.Now(),
+			fakeErr: fmt.Errorf("fake timeout %w", context.DeadlineExceeded),
+			errBody: `{"error":"no provider was able to supply the weather","providers":[{"provider":"fake","error":"fake timeout context deadline exceeded"}]}`,
+		},
+		"no provider knows the location": {
+			query:   "?postcode=0000&country=au",
+			status:  http.StatusNotFound,
+			myTime:  time.Now(),
+			fakeErr: fmt.Errorf("fake %w", ErrUnknownLocation),
+			errBody: `{"error":"no provider was able to supply the weather","providers":[{"provider":"fake","error":"fake unknown location"}]}`,
+		},
+		"all providers rate limited": {
+			query:   "?city=melbourne",
+			status:  http.StatusServiceUnavailable,
+			myTime:  time.Now(),
+			fakeErr: &retryAfterError{error: fmt.Errorf("fake %w", ErrRateLimited), wait: 1500 * time.Millisecond},
+			errBody: `{"error":"no provider was able to supply the weather","providers":[{"provider":"fake","error":"fake rate limited"}]}`,
+			header:  map[string]string{"Retry-After": "2"},
+		},
+		"all providers failed with stale value": {
+			query:    "?city=melbourne",
+			status:   http.StatusOK,
+			body:     Observation{Temperature: 10, WindSpeed: 15, Stale: true},
+			myTime:   time.Now(),
+			fakeErr:  fmt.Errorf("fake error"),
+			cached:   &Observation{Temperature: 10, WindSpeed: 15},
+			cacheAge: time.Hour,
+			header:   map[string]string{"Warning": `110 - "Response is Stale"`},
+		},
+		"all providers failed with expired value": {
+			query:    "?city=melbourne",
+			status:   http.StatusBadGateway,
 			myTime:   time.Now(),
 			fakeErr:  fmt.Errorf("fake error"),
+			cached:   &Observation{Temperature: 10, WindSpeed: 15},
+			cacheAge: time.Hour,
+			policy:   &CachePolicy{TTL: time.Second, StaleIfError: time.Minute},
+			errBody:  `{"error":"no provider was able to supply the weather","providers":[{"provider":"fake","error":"fake error"}]}`,
+		},
+		"other spellings share the cached value": {
+			query:    "?city=%20MELBOURNE%E2%80%8B",
+			status:   http.StatusOK,
+			body:     Observation{Temperature: 10, WindSpeed: 15},
+			response: Observation{Temperature: 100, WindSpeed: 150},
+			myTime:   time.Now(),
+			cached:   &Observation{Temperature: 10, WindSpeed: 15},
+			cacheAge: time.Second,
+		},
+		"fresh cached value": {
+			query:    "?city=melbourne",
+			status:   http.StatusOK,
+			body:     Observation{Temperature: 10, WindSpeed: 15},
+			response: Observation{Temperature: 100, WindSpeed: 150},
+			myTime:   time.Now(),
+			cached:   &Observation{Temperature: 10, WindSpeed: 15},
+			cacheAge: time.Second,
+		},
+		"expired cached value": {
+			query:    "?city=melbourne",
+			status:   http.StatusOK,
+			body:     Observation{Temperature: 100, WindSpeed: 150},
+			response: Observation{Temperature: 100, WindSpeed: 150},
+			myTime:   time.Now(),
+			cached:   &Observation{Temperature: 10, WindSpeed: 15},
+			cacheAge: time.Hour,
+		},
+		"stale while revalidate": {
+			query:       "?city=melbourne",
+			status:      http.StatusOK,
+			body:        Observation{Temperature: 10, WindSpeed: 15},
+			response:    Observation{Temperature: 100, WindSpeed: 150},
+			myTime:      time.Now(),
+			cached:      &Observation{Temperature: 10, WindSpeed: 15},
+			cacheAge:    time.Hour,
+			policy:      &CachePolicy{TTL: time.Minute, StaleWhileRevalidate: 2 * time.Hour},
+			revalidated: true,
 		},
 	}
+	defer func() { timeNow = time.Now }()
 	for name, tc := range testcases {
 		t.Run(name, func(t *testing.T) {
 			//create
-			o, err := New([]Provider{&fakeProvider{}})
+			policy := DefaultCachePolicy
+			if tc.policy != nil {
+				policy = *tc.policy
+			}
+			o, err := New([]Provider{&fakeProvider{}}, WithCachePolicy(policy))
 			assert.Nil(t, err)
+			if tc.cached != nil {
+				o.cache.Set("melbourne", Entry{Value: *tc.cached, Stored: tc.myTime.Add(-tc.cacheAge)})
+			}
 			timeNow = func() time.Time { return tc.myTime }
 			fakeResponse = tc.response
 			fakeResponseErr = tc.fakeErr
@@ -101,12 +290,12 @@ func TestWeatherHandler(t *testing.T) {
 
 			// Check results
 			assert.Equal(t, tc.status, rr.Code)
+			for k, v := range tc.header {
+				assert.Equal(t, v, rr.Header().Get(k))
+			}
 
 			if tc.errBody == "" {
-				body := struct {
-					Temperature float64 `json:"temperature_degrees"`
-					WindSpeed   float64 `json:"wind_speed"`
-				}{}
+				body := Observation{}
 				fmt.Println(rr.Body.String())
 				err = json.Unmarshal([]byte(rr.Body.String()), &body)
 				assert.Nil(t, err)
@@ -114,6 +303,13 @@ func TestWeatherHandler(t *testing.T) {
 			} else {
 				assert.Equal(t, tc.errBody, rr.Body.String())
 			}
+
+			if tc.revalidated {
+				assert.Eventually(t, func() bool {
+					entry, ok := o.cache.Get("melbourne")
+					return ok && entry.Value == tc.response
+				}, time.Second, time.Millisecond)
+			}
 		})
 	}
 }
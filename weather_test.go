@@ -1,6 +1,7 @@
 package weather_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -10,8 +11,8 @@ import (
 
 type fakeProvider struct{}
 
-func (f *fakeProvider) GetWeather(city string) (struct{ Temperature, WindSpeed float64 }, error) {
-	return struct{ Temperature, WindSpeed float64 }{}, nil
+func (f *fakeProvider) GetWeatherContext(ctx context.Context, loc weather.Location) (weather.Observation, error) {
+	return weather.Observation{}, nil
 }
 func TestNew(t *testing.T) {
 	testcases := map[string]struct {